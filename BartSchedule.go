@@ -1,32 +1,199 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+	"golang.org/x/time/rate"
 )
 
+// Build information, set at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
+// Default width of the station list column and the gap after it
+const (
+	defaultListWidth = 70
+	defaultGap       = 2
+)
+
+// Narrowest departures column worth showing beside the station list; any
+// narrower and the two are stacked instead
+const minDeparturesWidth = 28
+
+// How long a first 'q' waits for the second with --confirm-quit
+const quitConfirmWindow = 2 * time.Second
+
+// How long to wait before retrying after a network failure
+const networkRetryDelay = 5 * time.Second
+
+// How long the cursor must rest before auto-follow fetches departures
+const followDelay = 300 * time.Millisecond
+
+// Offline fixture of stations, routes and departures for --demo
+//
+//go:embed demo/demo.json
+var demoFixture []byte
+
+// Base URL of the BART API, overridable with --api-url or BART_API_URL
+// for mock servers and proxies
+var baseURL = "https://api.bart.gov/api"
+
 // Allow http.Get to be overridden in tests
-var httpGet = http.Get
+var httpGet = redactErrors(newHTTPClient().Get)
+
+// Longest a BART API request may take before it fails, so a hung
+// connection can't stall refreshes
+const requestTimeout = 15 * time.Second
+
+// Builds the client for BART API requests; the proxy is set explicitly so
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY keep working as with http.Get
+func newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	return &http.Client{Transport: transport, Timeout: requestTimeout}
+}
+
+// The API key query parameter, which every request URL carries
+var keyParam = regexp.MustCompile(`([?&]key=)[^&]*`)
+
+// Returned when the BART API rejects the API key
+var errInvalidAPIKey = errors.New("invalid BART API key")
+
+// Debug logger, discarding everything unless --log is given
+var logger = slog.New(slog.DiscardHandler)
+
+// Retry an empty departures response once after a short delay (--retry-empty)
+var (
+	retryEmpty      = false
+	retryEmptyDelay = 500 * time.Millisecond
+)
+
+// Estimates to request per destination with --count, 0 for BART's default
+var estimateCount = 0
+
+// Range BART accepts for the estimate count
+const (
+	minEstimateCount = 1
+	maxEstimateCount = 4
+)
+
+// Allow the time of day to be overridden in tests
+var clock = time.Now
+
+// Allow desktop notifications to be overridden in tests
+var notifyFunc = sendNotification
+
+// Allow clipboard access to be overridden in tests
+var clipboardFunc = copyToClipboard
+
+// Allow opening the browser to be overridden in tests
+var openURLFunc = openURL
 
 // Bubbletea model that stores the state of the program
 type model struct {
-	message      string    //	status message displayed at the top
-	stations     []station //	list of all the BART stations
-	err          error     //	error state if something fails
-	api_key      string    //	API key for the BART API
-	cursor       int       //	which station is currently selected on the list
-	info         string    //	departure info to be displayed
-	args         []string  //	optional CLI arguments
-	selectedName string    //	store selected station name for args
+	message         string                                //	status message displayed at the top
+	stations        []station                             //	list of all the BART stations
+	err             error                                 //	error state if something fails
+	api_key         string                                //	API key for the BART API
+	cursor          int                                   //	which station is currently selected on the list
+	info            string                                //	departure info to be displayed
+	args            []string                              //	optional CLI arguments
+	selectedName    string                                //	store selected station name for args
+	routes          []route                               //	list of all the BART routes (lines)
+	showRoutes      bool                                  //	whether the route list is displayed
+	routeCursor     int                                   //	which route is currently selected on the list
+	routeStops      []string                              //	ordered station abbreviations of the selected route
+	departures      map[string][]departureInfo            //	departures currently displayed
+	status          string                                //	status line shown above the footer
+	notify          []notifyRule                          //	commute alarms set with --notify
+	notified        map[string][]time.Time                //	arrival times already notified, per rule, until those trains leave
+	arriveAt        string                                //	destination to show scheduled arrivals for (--arrive)
+	arrivals        []trip                                //	next scheduled arrivals at arriveAt
+	remember        bool                                  //	save and restore the last viewed station
+	lastStation     string                                //	station restored from the previous run
+	renderOptions                                         //	how departures are rendered
+	width           int                                   //	terminal width, 0 until the first resize message
+	height          int                                   //	terminal height, 0 until the first resize message
+	refresh         time.Duration                         //	auto-refresh interval, 0 for the default
+	viewing         station                               //	station whose departures are displayed
+	direction       string                                //	only request "n" or "s" bound trains, empty for both
+	board           []string                              //	stations shown together on a multi-panel board
+	boardDeps       map[string]map[string][]departureInfo //	departures per board station
+	boardErrs       map[string]error                      //	fetch errors per board station
+	boardUpdated    map[string]time.Time                  //	when each board station's departures last refreshed
+	confirmQuit     bool                                  //	require a second q to quit (--confirm-quit)
+	quitPending     time.Time                             //	when q was first pressed, zero if no quit is pending
+	filter          string                                //	search text narrowing the station list
+	filtering       bool                                  //	whether the search box is taking input
+	near            *location                             //	user location from --near, nil if not given
+	autoSelect      bool                                  //	show departures as soon as the filter leaves one station (--auto-select)
+	showAdvisories  bool                                  //	full-screen service advisories toggled with a
+	advisories      []advisory                            //	current service advisories, nil until fetched
+	advisoryScroll  int                                   //	first advisory line shown when they don't fit
+	blurred         bool                                  //	terminal lost focus: ticks keep running but skip fetching
+	follow          bool                                  //	show departures for whichever station the cursor lands on
+	followSeq       int                                   //	bumped on each cursor move so only the last one fetches
+	lastUpdate      time.Time                             //	when the locked station's departures last refreshed successfully
+	stale           bool                                  //	the last refresh failed, so the departures shown are from lastUpdate
+	keys            *keyMap                               //	key bindings from the config file, nil for the defaults
+	wrapCursor      bool                                  //	up from the first station goes to the last, and back (--wrap)
+	nextRefresh     time.Time                             //	when the next tick is due, for the footer countdown
+	experimentalMap bool                                  //	draw estimated train positions along a line (--experimental-map)
+	trainMap        map[string]map[string][]departureInfo //	departures at every station, for the train map
+	favorites       []string                              //	starred station abbreviations, saved in the state file
+	selected        map[string]bool                       //	stations marked with + for a combined board
+	plainCursor     bool                                  //	mark the selected row by indenting it instead of a styled ">" (--plain-cursor)
+	withScheduled   bool                                  //	fill in scheduled trains after the live estimates (--with-scheduled)
+	loading         station                               //	station whose departures are being fetched, if any
+	spinner         spinner.Model                         //	animates the loading placeholder
+	once            time.Duration                         //	with --once, quit this long after departures show (or on any key) instead of refreshing
+	stationSort     int                                   //	station list order, one of the stationSorts (g cycles)
+	keepCursor      string                                //	abbreviation to put the cursor back on once the station list reloads
+	platformLabels  map[string]map[string]string          //	platform labels per station abbreviation, from the config
+	listCache       *stationListCache                     //	last rendered station list, shared by copies of the model; nil renders every time
+	schedules       *scheduleCache                        //	station schedules fetched for --with-scheduled; nil fetches every time
+	stationNames    map[string]string                     //	station names by abbreviation, kept when a station is locked
+	listWidth       int                                   //	station list column width, 0 for defaultListWidth (--list-width)
+	fitList         bool                                  //	size the station list column to the longest station name (--list-width 0)
+	gap             int                                   //	spaces between the columns, 0 for defaultGap (--gap)
+	firstTrains     map[string]time.Time                  //	first train per station once its service has ended, for an empty board
 }
 
 // Response shape for the BART "stations" API
@@ -40,44 +207,511 @@ type apiResponse struct {
 
 // Station object (name, abbreviation, city)
 type station struct {
-	Name string `json:"name"`
-	Abbr string `json:"abbr"`
-	City string `json:"city"`
+	Name      string `json:"name"`
+	Abbr      string `json:"abbr"`
+	City      string `json:"city"`
+	Latitude  string `json:"gtfs_latitude"`
+	Longitude string `json:"gtfs_longitude"`
 }
 
 // Response shape for the BART "ETD" API (estimated departures)
 type etdResponse struct {
 	Root struct {
-		Station []struct {
-			Abbr string `json:"abbr"`
-			Name string `json:"name"`
-			ETD  []struct {
-				Destination string `json:"destination"`
-				Estimate    []struct {
-					Minutes  string `json:"minutes"`
-					Platform string `json:"platform"`
-				} `json:"estimate"`
-			} `json:"etd"`
+		Station flexList[etdStation] `json:"station"`
+	} `json:"root"`
+}
+
+// Station entry in the ETD response
+type etdStation struct {
+	Abbr string        `json:"abbr"`
+	Name string        `json:"name"`
+	ETD  flexList[etd] `json:"etd"`
+}
+
+// Departures from a station towards one destination
+type etd struct {
+	Destination  string             `json:"destination"`
+	Abbreviation string             `json:"abbreviation"`
+	Estimate     flexList[estimate] `json:"estimate"`
+}
+
+// A single estimated departure
+type estimate struct {
+	Minutes   flexString `json:"minutes"`
+	Platform  flexString `json:"platform"`
+	Direction string     `json:"direction"`
+	Color     string     `json:"color"`
+	HexColor  string     `json:"hexcolor"`
+	Length    flexString `json:"length"`
+	BikeFlag  flexString `json:"bikeflag"`
+	Delay     flexString `json:"delay"`
+}
+
+// String that also decodes from a bare JSON number, in case BART stops
+// quoting numeric fields like minutes and platform
+type flexString string
+
+func (s *flexString) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*s = ""
+		return nil
+	}
+	if len(data) > 0 && data[0] != '"' {
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return err
+		}
+		*s = flexString(n.String())
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	*s = flexString(str)
+	return nil
+}
+
+// List that decodes from either a JSON array or a single JSON object,
+// since BART encodes one-item lists as a bare object
+type flexList[T any] []T
+
+func (l *flexList[T]) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) || bytes.Equal(data, []byte(`""`)) {
+		*l = nil
+		return nil
+	}
+	if len(data) > 0 && data[0] == '{' {
+		var item T
+		if err := json.Unmarshal(data, &item); err != nil {
+			return err
+		}
+		*l = flexList[T]{item}
+		return nil
+	}
+	var items []T
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	*l = items
+	return nil
+}
+
+// Error shape the BART API returns in place of data (e.g. for a bad key)
+type apiErrorResponse struct {
+	Root struct {
+		Message json.RawMessage `json:"message"`
+	} `json:"root"`
+}
+
+// Error details inside the "message" field of an error response
+type apiErrorMessage struct {
+	Error struct {
+		Text    string `json:"text"`
+		Details string `json:"details"`
+	} `json:"error"`
+}
+
+// Response shape for the BART "routes" API
+type routesResponse struct {
+	Root struct {
+		Routes struct {
+			Route []route `json:"route"`
+		} `json:"routes"`
+	} `json:"root"`
+}
+
+// Route object (name, abbreviation, number, color)
+type route struct {
+	Name     string `json:"name"`
+	Abbr     string `json:"abbr"`
+	RouteID  string `json:"routeID"`
+	Number   string `json:"number"`
+	HexColor string `json:"hexcolor"`
+	Color    string `json:"color"`
+}
+
+// Response shape for the BART "routeinfo" API
+type routeInfoResponse struct {
+	Root struct {
+		Routes struct {
+			Route struct {
+				Name   string `json:"name"`
+				Config struct {
+					Station []string `json:"station"`
+				} `json:"config"`
+			} `json:"route"`
+		} `json:"routes"`
+	} `json:"root"`
+}
+
+// Message carrying the result of a routes fetch
+type routesMsg struct {
+	routes []route
+	err    error
+}
+
+// A BART service advisory (BSA)
+type advisory struct {
+	Station     string `json:"station"`
+	Type        string `json:"type"`
+	Posted      string `json:"posted"`
+	Description struct {
+		Text string `json:"#cdata-section"`
+	} `json:"description"`
+}
+
+// Response shape for the BART advisories API
+type advisoriesResponse struct {
+	Root struct {
+		BSA flexList[advisory] `json:"bsa"`
+	} `json:"root"`
+}
+
+// Rebindable actions and their keys
+type keyMap struct {
+	Up      key.Binding
+	Down    key.Binding
+	Refresh key.Binding
+	Quit    key.Binding
+	Search  key.Binding
+	Enter   key.Binding
+}
+
+// Bindings used when the config file has no keymap
+func defaultKeyMap() keyMap {
+	return keyMap{
+		Up:      key.NewBinding(key.WithKeys("up", "w", "W"), key.WithHelp("up", "up")),
+		Down:    key.NewBinding(key.WithKeys("down", "s", "S"), key.WithHelp("down", "down")),
+		Refresh: key.NewBinding(key.WithKeys("r", "R"), key.WithHelp("r", "refresh")),
+		Quit:    key.NewBinding(key.WithKeys("ctrl+c", "q", "Q"), key.WithHelp("q", "quit")),
+		Search:  key.NewBinding(key.WithKeys("/"), key.WithHelp("/", "search")),
+		Enter:   key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "select")),
+	}
+}
+
+// Builds the key bindings from a config keymap such as {"up": ["k"]},
+// keeping the defaults for actions it doesn't mention
+func newKeyMap(cfg map[string][]string) (keyMap, error) {
+	km := defaultKeyMap()
+	for action, keys := range cfg {
+		if len(keys) == 0 {
+			return km, fmt.Errorf("no keys given for %q", action)
+		}
+		binding := key.NewBinding(key.WithKeys(keys...), key.WithHelp(keys[0], action))
+		switch action {
+		case "up":
+			km.Up = binding
+		case "down":
+			km.Down = binding
+		case "refresh":
+			km.Refresh = binding
+		case "quit":
+			km.Quit = binding
+		case "search":
+			km.Search = binding
+		case "enter":
+			km.Enter = binding
+		default:
+			return km, fmt.Errorf("unknown action %q, expected up, down, refresh, quit, search or enter", action)
+		}
+	}
+	return km, nil
+}
+
+// The action a key press is bound to, or "" if it isn't a rebindable one
+func (km keyMap) action(msg tea.KeyMsg) string {
+	switch {
+	case key.Matches(msg, km.Quit):
+		return "quit"
+	case key.Matches(msg, km.Search):
+		return "search"
+	case key.Matches(msg, km.Up):
+		return "up"
+	case key.Matches(msg, km.Down):
+		return "down"
+	case key.Matches(msg, km.Refresh):
+		return "refresh"
+	case key.Matches(msg, km.Enter):
+		return "enter"
+	}
+	return ""
+}
+
+// Key bindings in effect
+func (m model) keyMap() keyMap {
+	if m.keys != nil {
+		return *m.keys
+	}
+	return defaultKeyMap()
+}
+
+// Message carrying departures for every station, for the train map
+type trainMapMsg struct {
+	byStation map[string]map[string][]departureInfo
+	err       error
+}
+
+// Message sent once the cursor has rested after a move in auto-follow mode
+type followMsg struct {
+	seq int
+}
+
+// Message carrying the result of an advisories fetch
+type advisoriesMsg struct {
+	advisories []advisory
+	err        error
+}
+
+// Response shape for the BART "arrive" schedule API
+type scheduleResponse struct {
+	Root struct {
+		Schedule struct {
+			Request struct {
+				Trip flexList[trip] `json:"trip"`
+			} `json:"request"`
+		} `json:"schedule"`
+	} `json:"root"`
+}
+
+// A scheduled trip between two stations
+type trip struct {
+	Origin      string `json:"@origin"`
+	Destination string `json:"@destination"`
+	Fare        string `json:"@fare"`
+	OrigTime    string `json:"@origTimeMin"`
+	OrigDate    string `json:"@origTimeDate"`
+	DestTime    string `json:"@destTimeMin"`
+	DestDate    string `json:"@destTimeDate"`
+}
+
+// Response shape for the BART station schedule API
+type stationScheduleResponse struct {
+	Root struct {
+		Station struct {
+			Abbr string                 `json:"abbr"`
+			Item flexList[scheduleItem] `json:"item"`
 		} `json:"station"`
 	} `json:"root"`
 }
 
+// A train in a station's daily schedule
+type scheduleItem struct {
+	Line             string     `json:"@line"`
+	TrainHeadStation string     `json:"@trainHeadStation"`
+	OrigTime         string     `json:"@origTime"`
+	BikeFlag         flexString `json:"@bikeflag"`
+}
+
+// How far past the --at time scheduled departures are shown
+const scheduleWindow = time.Hour
+
+// Message carrying a station's departures (from fetchDepartures)
+type departuresMsg struct {
+	station station
+	deps    map[string][]departureInfo
+	err     error
+}
+
+// Message carrying the result of an arrivals fetch
+type arrivalsMsg struct {
+	trips []trip
+	err   error
+}
+
+// Styles for the parts of the UI that can be themed
+type theme struct {
+	Header    lipgloss.Style
+	Cursor    lipgloss.Style
+	Selected  lipgloss.Style
+	Departure lipgloss.Style
+	Next      lipgloss.Style //	soonest train for each destination
+	Faint     lipgloss.Style //	hints like the search completion and refresh countdown
+	Catchable lipgloss.Style //	trains you can walk to in time, with --walk
+	Missed    lipgloss.Style //	trains leaving before you could get there, with --walk
+}
+
+// Options controlling how departures are rendered
+type renderOptions struct {
+	theme         theme             //	styles used when rendering
+	terse         bool              //	"5 min" instead of "in 5 min"
+	minMinutes    int               //	hide trains leaving sooner than this
+	showLeaving   bool              //	keep "Leaving" trains even when hiding by minMinutes
+	showAll       bool              //	minMinutes filter toggled off at runtime
+	sortByTime    bool              //	order destinations and trains soonest first
+	nextOnly      bool              //	show only the soonest train for each destination
+	lineColors    bool              //	draw line markers in the line's color
+	colorProfile  termenv.Profile   //	colors the terminal supports, for line markers; zero is truecolor
+	symbols       bool              //	tag line markers with a letter, e.g. [Y], so they don't rely on color
+	banner        bool              //	draw station titles in a bordered box, like a departure board
+	columns       []string          //	fields shown per train, in order; nil for minutes and platform
+	histogram     bool              //	show a bar of trains per 10 minutes after each destination
+	leaving       string            //	label for a departing train, "" for "Leaving now" ("Leaving" with terse)
+	minuteSuffix  string            //	unit after the minutes, "" for "min"
+	maxPerDest    int               //	most trains shown per destination, 0 for all
+	allDirections bool              //	always show Northbound and Southbound, noting one without trains
+	walk          int               //	minutes to walk to the station, to tell catchable trains from missed ones
+	platforms     map[string]string //	labels for the shown station's platforms, e.g. "1": "lower"
+	firstTrain    time.Time         //	first train once service has ended, noted on an empty board
+}
+
+// Available color schemes, selected with --theme
+var themes = map[string]theme{
+	"dark": {
+		Header:    lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#5FD7FF")),
+		Cursor:    lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#FF87D7")),
+		Selected:  lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#FFFFFF")),
+		Departure: lipgloss.NewStyle().Foreground(lipgloss.Color("#D0D0D0")),
+		Next:      lipgloss.NewStyle().Bold(true).Underline(true).Foreground(lipgloss.Color("#FFFFFF")),
+		Faint:     lipgloss.NewStyle().Faint(true),
+		Catchable: lipgloss.NewStyle().Foreground(lipgloss.Color("#5FD75F")),
+		Missed:    lipgloss.NewStyle().Foreground(lipgloss.Color("#6C6C6C")),
+	},
+	"light": {
+		Header:    lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#005F87")),
+		Cursor:    lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#870087")),
+		Selected:  lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#000000")),
+		Departure: lipgloss.NewStyle().Foreground(lipgloss.Color("#3A3A3A")),
+		Next:      lipgloss.NewStyle().Bold(true).Underline(true).Foreground(lipgloss.Color("#000000")),
+		Faint:     lipgloss.NewStyle().Faint(true),
+		Catchable: lipgloss.NewStyle().Foreground(lipgloss.Color("#008700")),
+		Missed:    lipgloss.NewStyle().Foreground(lipgloss.Color("#8A8A8A")),
+	},
+	//	Official BART line colors
+	"bart": {
+		Header:    lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#0099CC")),
+		Cursor:    lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#FFFF33")),
+		Selected:  lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#FF9933")),
+		Departure: lipgloss.NewStyle().Foreground(lipgloss.Color("#339933")),
+		Next:      lipgloss.NewStyle().Bold(true).Underline(true).Foreground(lipgloss.Color("#339933")),
+		Faint:     lipgloss.NewStyle().Faint(true),
+		Catchable: lipgloss.NewStyle().Foreground(lipgloss.Color("#339933")),
+		Missed:    lipgloss.NewStyle().Foreground(lipgloss.Color("#6C6C6C")),
+	},
+}
+
+// Settings read from the config file
+type config struct {
+	APIKey         string                       `json:"api_key"`
+	Refresh        int                          `json:"refresh"` //	seconds between refreshes
+	Theme          string                       `json:"theme"`
+	DefaultStation string                       `json:"default_station"`
+	Presets        map[string][]string          `json:"presets"`         //	custom station groups for --preset
+	Keymap         map[string][]string          `json:"keymap"`          //	keys per action, replacing the defaults
+	LeavingLabel   string                       `json:"leaving_label"`   //	shown for a departing train instead of "Leaving now"
+	MinuteSuffix   string                       `json:"minute_suffix"`   //	unit after the minutes instead of "min", e.g. "m"
+	RateLimit      float64                      `json:"rate_limit"`      //	most API requests per second
+	PlatformLabels map[string]map[string]string `json:"platform_labels"` //	per station and platform, e.g. {"MONT": {"1": "lower"}}
+}
+
+// Named station groups for --preset
+var presets = map[string][]string{
+	"downtown": {"EMBR", "MONT", "POWL", "CIVC"},
+}
+
+// Message carrying departures for every station on the board
+type boardMsg struct {
+	deps map[string]map[string][]departureInfo
+	errs map[string]error
+}
+
+// State persisted between runs
+type appState struct {
+	LastStation string   `json:"last_station"`
+	Direction   string   `json:"direction,omitempty"`
+	SortByTime  bool     `json:"sort_by_time,omitempty"`
+	Favorites   []string `json:"favorites,omitempty"`
+	StationSort string   `json:"station_sort,omitempty"` //	one of the stationSorts, empty as listed
+}
+
+// A point given in decimal degrees
+type location struct {
+	Lat, Lon float64
+}
+
+// Average walking speed used for walking time estimates
+const walkingKmPerHour = 5.0
+
 // Simple departure information
 type departureInfo struct {
-	Minutes  string
-	Platform string
+	Minutes   string `json:"minutes"`
+	Platform  string `json:"platform"`
+	Direction string `json:"direction"`
+	Color     string `json:"color"`               //	line color, e.g. "YELLOW"
+	HexColor  string `json:"hex_color"`           //	line color as hex, e.g. "#ffff33"
+	Length    string `json:"length"`              //	number of cars
+	BikeFlag  string `json:"bike_flag"`           //	"1" if bikes are allowed
+	Delay     string `json:"delay"`               //	seconds behind schedule
+	Time      string `json:"time,omitempty"`      //	scheduled departure time, e.g. "6:04 PM"; empty for live estimates
+	Dest      string `json:"dest_abbr,omitempty"` //	destination abbreviation, e.g. "DUBL"
+}
+
+// One station's departures as written by --json, one object per line
+type departuresJSON struct {
+	Station    string                     `json:"station"`
+	Updated    time.Time                  `json:"updated"`
+	Departures map[string][]departureInfo `json:"departures"` //	keyed by destination name
 }
 
 type tickMsg struct{}
 
+// Commute alarm: notify when a train to Destination from Station is Minutes away
+type notifyRule struct {
+	Station     string
+	Destination string
+	Minutes     int
+}
+
+// Repeatable --notify flag value
+type notifyFlag []notifyRule
+
+func (f *notifyFlag) String() string {
+	var rules []string
+	for _, r := range *f {
+		rules = append(rules, fmt.Sprintf("%s:%s:%d", r.Station, r.Destination, r.Minutes))
+	}
+	return strings.Join(rules, ",")
+}
+
+func (f *notifyFlag) Set(value string) error {
+	rule, err := parseNotifyRule(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, rule)
+	return nil
+}
+
+// Parses a STATION:DESTINATION:MINUTES notify rule (e.g. "POWL:Dublin:6")
+func parseNotifyRule(value string) (notifyRule, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return notifyRule{}, fmt.Errorf("expected STATION:DESTINATION:MINUTES, got %q", value)
+	}
+	minutes, err := strconv.Atoi(parts[2])
+	if err != nil || minutes < 0 {
+		return notifyRule{}, fmt.Errorf("invalid minutes %q in %q", parts[2], value)
+	}
+	return notifyRule{
+		Station:     strings.ToUpper(parts[0]),
+		Destination: parts[1],
+		Minutes:     minutes,
+	}, nil
+}
+
 // Creates the initial Bubble Tea model
 func initialModel(api_key string, args []string) model {
 	return model{
-		message: "\nLoading Bart stations...",
-		api_key: api_key,
-		cursor:  0,
-		info:    "",
-		args:    args,
+		message:   "\nLoading Bart stations...",
+		api_key:   api_key,
+		cursor:    0,
+		info:      "",
+		args:      args,
+		spinner:   spinner.New(spinner.WithSpinner(spinner.Dot)),
+		listCache: &stationListCache{},
+		schedules: &scheduleCache{},
 	}
 }
 
@@ -86,16 +720,183 @@ func (m model) Init() tea.Cmd {
 	return tea.Batch(
 		tea.SetWindowTitle("BART Schedule"),
 		fetchStations(m.api_key), //	fetch the station list immediately
-		tea.Tick(5*time.Second, func(time.Time) tea.Msg {
-			return tickMsg{}
-		}),
+		m.spinner.Tick,
+		m.fetchBoard(),
+		tick(m.refreshInterval()),
+		countdown(),
 	)
 }
 
+// Hides the API key in a request URL, for logs and error messages
+func redactKey(rawURL string) string {
+	return keyParam.ReplaceAllString(rawURL, "${1}****")
+}
+
+// Wraps an HTTP getter so its errors, which quote the request URL, don't
+// include the API key
+func redactErrors(get func(string) (*http.Response, error)) func(string) (*http.Response, error) {
+	return func(rawURL string) (*http.Response, error) {
+		resp, err := get(rawURL)
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactKey(urlErr.URL)
+		}
+		return resp, err
+	}
+}
+
+// Requests allowed back to back before --rate-limit spaces them out
+const rateBurst = 5
+
+// Wraps an HTTP getter so requests wait their turn under a shared rate
+// limit; the TUI fetches in commands, so waiting doesn't block the UI
+func throttle(get func(string) (*http.Response, error), limiter *rate.Limiter) func(string) (*http.Response, error) {
+	return func(rawURL string) (*http.Response, error) {
+		if err := limiter.Wait(context.Background()); err != nil {
+			return nil, err
+		}
+		return get(rawURL)
+	}
+}
+
+// Wraps an HTTP getter so every request, response status and error is logged
+func logRequests(get func(string) (*http.Response, error)) func(string) (*http.Response, error) {
+	return func(url string) (*http.Response, error) {
+		start := time.Now()
+		resp, err := get(url)
+		if err != nil {
+			logger.Error("request failed", "url", redactKey(url), "err", err)
+			return resp, err
+		}
+		logger.Info("request", "url", redactKey(url), "status", resp.StatusCode, "duration", time.Since(start))
+		return resp, nil
+	}
+}
+
+// Serves BART API requests from the embedded demo fixture, so every view
+// works offline through the same fetch and render paths
+func demoGet(rawURL string) (*http.Response, error) {
+	var fixture struct {
+		Stations json.RawMessage            `json:"stations"`
+		Routes   json.RawMessage            `json:"routes"`
+		BSA      json.RawMessage            `json:"bsa"`
+		ETD      map[string]json.RawMessage `json:"etd"`
+	}
+	if err := json.Unmarshal(demoFixture, &fixture); err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+
+	body := json.RawMessage(`{"root": {}}`)
+	switch {
+	case strings.HasSuffix(u.Path, "/stn.aspx"):
+		body = fixture.Stations
+	case strings.HasSuffix(u.Path, "/route.aspx") && q.Get("cmd") == "routes":
+		body = fixture.Routes
+	case strings.HasSuffix(u.Path, "/bsa.aspx"):
+		body = fixture.BSA
+	case strings.HasSuffix(u.Path, "/etd.aspx"):
+		if etd, ok := fixture.ETD[strings.ToUpper(q.Get("orig"))]; ok {
+			body = etd
+		}
+	case strings.HasSuffix(u.Path, "/sched.aspx") && q.Get("cmd") == "stnsched":
+		body, err = demoStationSchedule(fixture.ETD[strings.ToUpper(q.Get("orig"))])
+	case strings.HasSuffix(u.Path, "/sched.aspx") && q.Get("cmd") == "arrive":
+		body, err = demoTrips(strings.ToUpper(q.Get("orig")), strings.ToUpper(q.Get("dest")), clock())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    &http.Request{Method: http.MethodGet, URL: u},
+	}, nil
+}
+
+// A demo station's daily schedule: a train to each of its fixture
+// destinations every 15 minutes from 5 AM until midnight
+func demoStationSchedule(etdBody json.RawMessage) (json.RawMessage, error) {
+	var data etdResponse
+	if len(etdBody) > 0 {
+		if err := json.Unmarshal(etdBody, &data); err != nil {
+			return nil, err
+		}
+	}
+
+	var resp stationScheduleResponse
+	for _, st := range data.Root.Station {
+		resp.Root.Station.Abbr = st.Abbr
+		for i, e := range st.ETD {
+			//	Stagger the destinations so their trains don't all leave together
+			for at := time.Date(2000, 1, 1, 5, i*4%15, 0, 0, time.UTC); at.Day() == 1; at = at.Add(15 * time.Minute) {
+				resp.Root.Station.Item = append(resp.Root.Station.Item, scheduleItem{
+					TrainHeadStation: e.Abbreviation,
+					OrigTime:         at.Format("3:04 PM"),
+					BikeFlag:         "1",
+				})
+			}
+		}
+	}
+	return json.Marshal(resp)
+}
+
+// The next few demo trips from orig to dest after now, every 15 minutes
+func demoTrips(orig, dest string, now time.Time) (json.RawMessage, error) {
+	var resp scheduleResponse
+	start := now.Truncate(15 * time.Minute).Add(15 * time.Minute)
+	for i := range 4 {
+		leave := start.Add(time.Duration(i) * 15 * time.Minute)
+		arrive := leave.Add(20 * time.Minute)
+		resp.Root.Schedule.Request.Trip = append(resp.Root.Schedule.Request.Trip, trip{
+			Origin:      orig,
+			Destination: dest,
+			OrigTime:    leave.Format("3:04 PM"),
+			OrigDate:    leave.Format("01/02/2006"),
+			DestTime:    arrive.Format("3:04 PM"),
+			DestDate:    arrive.Format("01/02/2006"),
+		})
+	}
+	return json.Marshal(resp)
+}
+
+// Schedules the next auto-refresh tick
+func tick(d time.Duration) tea.Cmd {
+	return tea.Tick(d, func(time.Time) tea.Msg {
+		return tickMsg{}
+	})
+}
+
+// Message redrawing the refresh countdown once a second
+type countdownMsg struct{}
+
+// Schedules the next countdown redraw
+func countdown() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return countdownMsg{}
+	})
+}
+
+// How often departures auto-refresh, defaulting to every 5 seconds
+func (m model) refreshInterval() time.Duration {
+	if m.refresh > 0 {
+		return m.refresh
+	}
+	return 5 * time.Second
+}
+
 // Fetch the list of all stations
 func fetchStations(apiKey string) tea.Cmd {
 	return func() tea.Msg {
-		url := fmt.Sprintf("https://api.bart.gov/api/stn.aspx?cmd=stns&key=%s&json=y", apiKey)
+		url := fmt.Sprintf("%s/stn.aspx?cmd=stns&key=%s&json=y", baseURL, apiKey)
 		resp, err := httpGet(url)
 		if err != nil {
 			return err
@@ -106,304 +907,3757 @@ func fetchStations(apiKey string) tea.Cmd {
 			return err
 		}
 		var data apiResponse
-		if err := json.Unmarshal(body, &data); err != nil {
+		if err := decodeJSON(resp, body, &data); err != nil {
 			return err
 		}
 
 		//	Return the stations as a message for Update()
-		return data.Root.Stations.Station
+		return usableStations(data.Root.Stations.Station)
 	}
 }
 
-// Fetch departure times for a given station abbreviation
-func getDepartures(apiKey, stationAbbr string) (map[string][]departureInfo, error) {
-	url := fmt.Sprintf(
-		"https://api.bart.gov/api/etd.aspx?cmd=etd&orig=%s&key=%s&json=y",
-		stationAbbr, apiKey,
-	)
+// Drops stations without an abbreviation, which can't be looked up, and
+// names stations missing a name after their abbreviation
+func usableStations(stations []station) []station {
+	var usable []station
+	for _, st := range stations {
+		st.Abbr = strings.TrimSpace(st.Abbr)
+		if st.Abbr == "" {
+			logger.Warn("skipping station without an abbreviation", "name", st.Name)
+			continue
+		}
+		if strings.TrimSpace(st.Name) == "" {
+			st.Name = st.Abbr
+		}
+		usable = append(usable, st)
+	}
+	return usable
+}
 
+// Makes a lightweight request to check the API key before launching the TUI.
+// Returns errInvalidAPIKey if BART rejects the key, or the underlying error
+// if the API could not be reached.
+func validateAPIKey(apiKey string) error {
+	url := fmt.Sprintf("%s/stn.aspx?cmd=stns&key=%s&json=y", baseURL, apiKey)
 	resp, err := httpGet(url)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return errInvalidAPIKey
+	}
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	var data etdResponse
+	//	A rejected key comes back as an error message instead of stations
+	var data apiErrorResponse
 	if err := json.Unmarshal(body, &data); err != nil {
+		return nil
+	}
+	var msg apiErrorMessage
+	if err := json.Unmarshal(data.Root.Message, &msg); err != nil {
+		return nil
+	}
+	text := strings.ToLower(msg.Error.Text + " " + msg.Error.Details)
+	if strings.Contains(text, "key") {
+		return errInvalidAPIKey
+	}
+	return nil
+}
+
+// Fetch departure times for a given station abbreviation, optionally only
+// in one direction ("n" or "s"; empty for both)
+func getDepartures(apiKey, stationAbbr, direction string) (map[string][]departureInfo, error) {
+	url := fmt.Sprintf(
+		"%s/etd.aspx?cmd=etd&orig=%s&key=%s&json=y",
+		baseURL, stationAbbr, apiKey,
+	)
+	if direction != "" {
+		url += "&dir=" + direction
+	}
+	if estimateCount > 0 {
+		url += fmt.Sprintf("&a=%d&b=0", estimateCount)
+	}
+
+	data, err := fetchETD(url)
+	if err != nil {
 		return nil, err
 	}
 
-	departures := make(map[string][]departureInfo)
+	//	An empty response is sometimes a transient blip, so optionally
+	//	ask once more before concluding there are no departures
+	if len(data.Root.Station) == 0 && retryEmpty {
+		time.Sleep(retryEmptyDelay)
+		if data, err = fetchETD(url); err != nil {
+			return nil, err
+		}
+	}
 
 	//	If no station data returned, exit early
 	if len(data.Root.Station) == 0 {
+		return make(map[string][]departureInfo), nil
+	}
+
+	//	A single-origin request returns one station; if there are more, only
+	//	use the requested one so destinations from other stations can't collide
+	byStation := departuresByStation(data)
+	if len(data.Root.Station) == 1 {
+		return byStation[strings.ToUpper(data.Root.Station[0].Abbr)], nil
+	}
+	if departures, ok := byStation[strings.ToUpper(stationAbbr)]; ok {
 		return departures, nil
 	}
+	return nil, fmt.Errorf("ETD response has %d stations, none of them %s", len(data.Root.Station), stationAbbr)
+}
+
+// Decodes a BART API response body, explaining failures: an HTML page
+// (usually an outage notice) or JSON that doesn't parse, with the status
+// and the start of the body
+func decodeJSON(resp *http.Response, body []byte, v any) error {
+	err := json.Unmarshal(body, v)
+	if err == nil {
+		return nil
+	}
+	status := resp.Status
+	if status == "" {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("<")) {
+		return fmt.Errorf("BART API returned an unexpected (non-JSON) response (%s)", status)
+	}
+
+	snippet := string(bytes.TrimSpace(body))
+	if len(snippet) > 80 {
+		snippet = snippet[:80] + "..."
+	}
+	return fmt.Errorf("decoding BART API response (%s): %w: %q", status, err, snippet)
+}
+
+// Requests and decodes an ETD response
+func fetchETD(url string) (etdResponse, error) {
+	var data etdResponse
+
+	resp, err := httpGet(url)
+	if err != nil {
+		return data, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return data, err
+	}
+
+	err = decodeJSON(resp, body, &data)
+	return data, err
+}
+
+// Collects ETD departures keyed by origin station abbreviation, then destination
+func departuresByStation(data etdResponse) map[string]map[string][]departureInfo {
+	byStation := make(map[string]map[string][]departureInfo)
 
 	// Loop through ETD data and collect departures
 	for _, st := range data.Root.Station {
+		abbr := strings.ToUpper(st.Abbr)
+		departures, ok := byStation[abbr]
+		if !ok {
+			departures = make(map[string][]departureInfo)
+			byStation[abbr] = departures
+		}
 		for _, etd := range st.ETD {
 			dest := etd.Destination
 			for _, est := range etd.Estimate {
 				departures[dest] = append(departures[dest], departureInfo{
-					Minutes:  est.Minutes,
-					Platform: est.Platform,
+					Minutes:   string(est.Minutes),
+					Platform:  string(est.Platform),
+					Direction: est.Direction,
+					Color:     est.Color,
+					HexColor:  est.HexColor,
+					Length:    string(est.Length),
+					BikeFlag:  string(est.BikeFlag),
+					Delay:     string(est.Delay),
+					Dest:      etd.Abbreviation,
 				})
 			}
 		}
 	}
 
-	return departures, nil
+	return byStation
 }
 
-// Handles user input and incoming messages
-func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch msg := msg.(type) {
+// Fetch the list of all routes (lines)
+func getRoutes(apiKey string) ([]route, error) {
+	url := fmt.Sprintf("%s/route.aspx?cmd=routes&key=%s&json=y", baseURL, apiKey)
 
-	//	Handles keypresses
-	case tea.KeyMsg:
-		switch msg.String() {
-		case "ctrl+c", "q", "Q":
-			return m, tea.Quit
-		case "up", "w", "W":
-			if m.cursor > 0 {
+	resp, err := httpGet(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var data routesResponse
+	if err := decodeJSON(resp, body, &data); err != nil {
+		return nil, err
+	}
+
+	return data.Root.Routes.Route, nil
+}
+
+// Fetch the route list as a command for Update()
+func fetchRoutes(apiKey string) tea.Cmd {
+	return func() tea.Msg {
+		routes, err := getRoutes(apiKey)
+		return routesMsg{routes: routes, err: err}
+	}
+}
+
+// Fetches departures for every station in one request, for the train map
+func fetchTrainMap(apiKey string) tea.Cmd {
+	return func() tea.Msg {
+		url := fmt.Sprintf("%s/etd.aspx?cmd=etd&orig=ALL&key=%s&json=y", baseURL, apiKey)
+		data, err := fetchETD(url)
+		if err != nil {
+			return trainMapMsg{err: err}
+		}
+		return trainMapMsg{byStation: departuresByStation(data)}
+	}
+}
+
+// Gets the current service advisories
+func getAdvisories(apiKey string) ([]advisory, error) {
+	url := fmt.Sprintf("%s/bsa.aspx?cmd=bsa&key=%s&json=y", baseURL, apiKey)
+
+	resp, err := httpGet(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var data advisoriesResponse
+	if err := decodeJSON(resp, body, &data); err != nil {
+		return nil, err
+	}
+
+	return data.Root.BSA, nil
+}
+
+// Fetches the service advisories in the background
+func fetchAdvisories(apiKey string) tea.Cmd {
+	return func() tea.Msg {
+		advisories, err := getAdvisories(apiKey)
+		return advisoriesMsg{advisories: advisories, err: err}
+	}
+}
+
+// Message carrying the stations served by a route
+type routeInfoMsg struct {
+	stops []string
+	err   error
+}
+
+// Fetch the ordered list of station abbreviations served by a route
+func getRouteInfo(apiKey string, routeNum int) ([]string, error) {
+	url := fmt.Sprintf(
+		"%s/route.aspx?cmd=routeinfo&route=%d&key=%s&json=y",
+		baseURL, routeNum, apiKey,
+	)
+
+	resp, err := httpGet(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var data routeInfoResponse
+	if err := decodeJSON(resp, body, &data); err != nil {
+		return nil, err
+	}
+
+	return data.Root.Routes.Route.Config.Station, nil
+}
+
+// Fetch a route's stations as a command for Update()
+func fetchRouteInfo(apiKey string, routeNum int) tea.Cmd {
+	return func() tea.Msg {
+		stops, err := getRouteInfo(apiKey, routeNum)
+		return routeInfoMsg{stops: stops, err: err}
+	}
+}
+
+// Fetch the next scheduled trips from orig that arrive at dest
+func getArrivals(apiKey, orig, dest string) ([]trip, error) {
+	url := fmt.Sprintf(
+		"%s/sched.aspx?cmd=arrive&orig=%s&dest=%s&key=%s&json=y",
+		baseURL, orig, dest, apiKey,
+	)
+
+	resp, err := httpGet(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var data scheduleResponse
+	if err := decodeJSON(resp, body, &data); err != nil {
+		return nil, err
+	}
+
+	return data.Root.Schedule.Request.Trip, nil
+}
+
+// Fetch the scheduled (not real-time) departures from orig in the hour
+// after t, keyed by destination abbreviation
+func getScheduledDepartures(apiKey, orig string, t time.Time) (map[string][]departureInfo, error) {
+	items, err := getStationSchedule(apiKey, orig, serviceDay(t))
+	if err != nil {
+		return nil, err
+	}
+	return scheduledWithin(items, t), nil
+}
+
+// The day whose schedule t falls in: trains after midnight run on the
+// previous day's schedule
+func serviceDay(t time.Time) time.Time {
+	if t.Hour() < 3 {
+		return t.AddDate(0, 0, -1)
+	}
+	return t
+}
+
+// The trains of a station's schedule leaving in the hour after t, keyed by
+// destination abbreviation
+func scheduledWithin(items []scheduleItem, t time.Time) map[string][]departureInfo {
+	day := serviceDay(t)
+	departures := make(map[string][]departureInfo)
+	for _, item := range items {
+		at, err := time.Parse("3:04 PM", strings.TrimSpace(item.OrigTime))
+		if err != nil {
+			continue
+		}
+		dep := time.Date(day.Year(), day.Month(), day.Day(), at.Hour(), at.Minute(), 0, 0, t.Location())
+		if at.Hour() < 3 {
+			dep = dep.AddDate(0, 0, 1)
+		}
+		if dep.Before(t) || !dep.Before(t.Add(scheduleWindow)) {
+			continue
+		}
+		dest := item.TrainHeadStation
+		departures[dest] = append(departures[dest], departureInfo{
+			Minutes:  strconv.Itoa(int(dep.Sub(t).Minutes())),
+			Time:     dep.Format("3:04 PM"),
+			BikeFlag: string(item.BikeFlag),
+			Dest:     dest,
+		})
+	}
+	return departures
+}
+
+// Station schedules already fetched, so refreshes with --with-scheduled
+// fetch each station's day schedule once. Shared by copies of the model and
+// used from fetch commands, hence the lock.
+type scheduleCache struct {
+	mu    sync.Mutex
+	items map[string]cachedSchedule //	by station abbreviation
+}
+
+// A station's schedule for one service day
+type cachedSchedule struct {
+	day   string
+	items []scheduleItem
+}
+
+// orig's schedule for the service day starting on day, fetched only the
+// first time it's asked for. A nil cache fetches every time.
+func (c *scheduleCache) get(apiKey, orig string, day time.Time) ([]scheduleItem, error) {
+	if c == nil {
+		return getStationSchedule(apiKey, orig, day)
+	}
+	key := day.Format("2006-01-02")
+	c.mu.Lock()
+	cached, ok := c.items[orig]
+	c.mu.Unlock()
+	if ok && cached.day == key {
+		return cached.items, nil
+	}
+
+	items, err := getStationSchedule(apiKey, orig, day)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.items == nil {
+		c.items = make(map[string]cachedSchedule)
+	}
+	c.items[orig] = cachedSchedule{day: key, items: items}
+	return items, nil
+}
+
+// Fetch every train in orig's schedule for the service day starting on day
+func getStationSchedule(apiKey, orig string, day time.Time) ([]scheduleItem, error) {
+	url := fmt.Sprintf(
+		"%s/sched.aspx?cmd=stnsched&orig=%s&date=%s&key=%s&json=y",
+		baseURL, orig, day.Format("01/02/2006"), apiKey,
+	)
+
+	resp, err := httpGet(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var data stationScheduleResponse
+	if err := decodeJSON(resp, body, &data); err != nil {
+		return nil, err
+	}
+	return data.Root.Station.Item, nil
+}
+
+// The end of the service day, from 10 PM until 3 AM, when short or empty
+// boards mean service is winding down
+func lateNight(now time.Time) bool {
+	hour := now.Hour()
+	return hour >= 22 || hour < 3
+}
+
+// The first scheduled train from orig after the night's service: that
+// morning's first train when it's past midnight, else tomorrow's. Zero if
+// it has already left.
+func firstScheduledTrain(apiKey, orig string, now time.Time) (time.Time, error) {
+	day := now
+	if now.Hour() >= 12 {
+		day = now.AddDate(0, 0, 1)
+	}
+	items, err := getStationSchedule(apiKey, orig, day)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var first time.Time
+	for _, item := range items {
+		at, err := time.Parse("3:04 PM", strings.TrimSpace(item.OrigTime))
+		//	Trains after midnight end the previous night's service
+		if err != nil || at.Hour() < 3 {
+			continue
+		}
+		dep := time.Date(day.Year(), day.Month(), day.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+		if first.IsZero() || dep.Before(first) {
+			first = dep
+		}
+	}
+	if !first.After(now) {
+		return time.Time{}, nil
+	}
+	return first, nil
+}
+
+// Scheduled trains this close after the last live estimate are taken to be
+// the same train running early, not another one
+const scheduleOverlap = 2
+
+// Fills in departures after the live estimates run out with scheduled ones,
+// matched to live destinations by abbreviation. Destinations with no live
+// trains are named from stations (names by abbreviation) when known.
+func mergeScheduled(live, scheduled map[string][]departureInfo, stations map[string]string) map[string][]departureInfo {
+	merged := make(map[string][]departureInfo)
+	names := make(map[string]string)
+	for dest, depList := range live {
+		merged[dest] = slices.Clone(depList)
+		for _, dep := range depList {
+			if dep.Dest != "" {
+				names[dep.Dest] = dest
+			}
+		}
+	}
+
+	for abbr, depList := range scheduled {
+		dest := abbr
+		if name, ok := names[abbr]; ok {
+			dest = name
+		} else if name, ok := stations[abbr]; ok {
+			dest = name
+		}
+
+		//	Only trains after the last live one, on its platform and line
+		last, latest := -1, departureInfo{}
+		for _, dep := range live[dest] {
+			if min, ok := parseMinutes(dep.Minutes); ok && min >= last {
+				last, latest = min, dep
+			}
+		}
+		for _, dep := range depList {
+			if min, ok := parseMinutes(dep.Minutes); !ok || (last >= 0 && min <= last+scheduleOverlap) {
+				continue
+			}
+			dep.Platform, dep.Direction = latest.Platform, latest.Direction
+			dep.Color, dep.HexColor = latest.Color, latest.HexColor
+			merged[dest] = append(merged[dest], dep)
+		}
+	}
+	return merged
+}
+
+// Parses an --at time like "18:00" or "6:00pm" as the next such time after now
+func parseAtTime(value string, now time.Time) (time.Time, error) {
+	value = strings.ToUpper(strings.ReplaceAll(value, " ", ""))
+	var at time.Time
+	var err error
+	for _, layout := range []string{"15:04", "3:04PM", "3PM"} {
+		if at, err = time.Parse(layout, value); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected a time like 18:00 or 6:00pm, got %q", value)
+	}
+
+	t := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	if t.Before(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// Parses a trip's date ("10/16/2026") and time ("5:02 PM")
+func parseTripTime(date, clock string) (time.Time, error) {
+	return time.Parse("01/02/2006 3:04 PM", strings.TrimSpace(date)+" "+strings.TrimSpace(clock))
+}
+
+// The America/Los_Angeles time zone that trip times are given in, as the
+// VTIMEZONE calendar files must define for the TZID they use
+const pacificTimezone = "BEGIN:VTIMEZONE\r\n" +
+	"TZID:America/Los_Angeles\r\n" +
+	"BEGIN:DAYLIGHT\r\n" +
+	"TZOFFSETFROM:-0800\r\n" +
+	"TZOFFSETTO:-0700\r\n" +
+	"TZNAME:PDT\r\n" +
+	"DTSTART:20070311T020000\r\n" +
+	"RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=2SU\r\n" +
+	"END:DAYLIGHT\r\n" +
+	"BEGIN:STANDARD\r\n" +
+	"TZOFFSETFROM:-0700\r\n" +
+	"TZOFFSETTO:-0800\r\n" +
+	"TZNAME:PST\r\n" +
+	"DTSTART:20071104T020000\r\n" +
+	"RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=1SU\r\n" +
+	"END:STANDARD\r\n" +
+	"END:VTIMEZONE\r\n"
+
+// Builds an iCalendar file with a single event for the trip
+func tripToICS(t trip) []byte {
+	const layout = "20060102T150405"
+
+	var b strings.Builder
+	b.WriteString("BEGIN:VCALENDAR\r\n")
+	b.WriteString("VERSION:2.0\r\n")
+	b.WriteString("PRODID:-//bart-schedule//EN\r\n")
+	b.WriteString(pacificTimezone)
+	b.WriteString("BEGIN:VEVENT\r\n")
+	fmt.Fprintf(&b, "UID:%s-%s-%s-%s@bart-schedule\r\n",
+		t.Origin, t.Destination, strings.ReplaceAll(t.OrigDate, "/", ""), strings.ReplaceAll(t.OrigTime, " ", ""))
+	fmt.Fprintf(&b, "DTSTAMP:%s\r\n", clock().UTC().Format(layout+"Z"))
+	if start, err := parseTripTime(t.OrigDate, t.OrigTime); err == nil {
+		fmt.Fprintf(&b, "DTSTART;TZID=America/Los_Angeles:%s\r\n", start.Format(layout))
+	}
+	if end, err := parseTripTime(t.DestDate, t.DestTime); err == nil {
+		fmt.Fprintf(&b, "DTEND;TZID=America/Los_Angeles:%s\r\n", end.Format(layout))
+	}
+	fmt.Fprintf(&b, "SUMMARY:BART %s → %s\r\n", t.Origin, t.Destination)
+	fmt.Fprintf(&b, "DESCRIPTION:Depart %s %s\\, arrive %s %s\r\n", t.Origin, t.OrigTime, t.Destination, t.DestTime)
+	b.WriteString("END:VEVENT\r\n")
+	b.WriteString("END:VCALENDAR\r\n")
+	return []byte(b.String())
+}
+
+// Fetch arrivals as a command for Update()
+func fetchArrivals(apiKey, orig, dest string) tea.Cmd {
+	return func() tea.Msg {
+		trips, err := getArrivals(apiKey, orig, dest)
+		return arrivalsMsg{trips: trips, err: err}
+	}
+}
+
+// Endpoints of a route, taken from its abbreviation (e.g. "ANTC-SFIA")
+func (r route) endpoints() (string, string) {
+	parts := strings.SplitN(r.Abbr, "-", 2)
+	if len(parts) != 2 {
+		return r.Abbr, ""
+	}
+	return parts[0], parts[1]
+}
+
+// Reports whether a departure is filtered out by the minimum-minutes option
+func (opts renderOptions) hides(dep departureInfo) bool {
+	if opts.minMinutes == 0 || opts.showAll {
+		return false
+	}
+	if dep.Minutes == "Leaving" && opts.showLeaving {
+		return false
+	}
+	min, ok := parseMinutes(dep.Minutes)
+	return ok && min < opts.minMinutes
+}
+
+// Reports whether a train leaves before a --walk to the station would get there
+func (opts renderOptions) missed(dep departureInfo) bool {
+	min, ok := parseMinutes(dep.Minutes)
+	return opts.walk > 0 && ok && min < opts.walk
+}
+
+// Summarizes how connected a station is, e.g. "4 destinations, 2 platforms"
+func stationStats(deps map[string][]departureInfo) string {
+	platforms := make(map[string]bool)
+	for _, depList := range deps {
+		for _, dep := range depList {
+			if dep.Platform != "" {
+				platforms[dep.Platform] = true
+			}
+		}
+	}
+	return plural(len(deps), "destination") + ", " + plural(len(platforms), "platform")
+}
+
+// Formats a count with a singular or plural noun, e.g. "1 platform", "2 platforms"
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// Phrases an estimate's minutes naturally: "Leaving now", "in 1 min", "in 5 min"
+func (o renderOptions) humanizeMinutes(minutes string) string {
+	if minutes == "Leaving" {
+		if o.leaving != "" {
+			return o.leaving
+		}
+		return "Leaving now"
+	}
+	if _, err := strconv.Atoi(minutes); err != nil {
+		return minutes
+	}
+	return "in " + minutes + " " + o.minuteUnit()
+}
+
+// Unit after the minutes, "min" unless the config sets one
+func (o renderOptions) minuteUnit() string {
+	if o.minuteSuffix != "" {
+		return o.minuteSuffix
+	}
+	return "min"
+}
+
+// Explains an empty board, with the first train once service has ended
+func (o renderOptions) noTrains() string {
+	if !o.firstTrain.IsZero() {
+		return "Service ended — first train at " + o.firstTrain.Format("3:04 PM")
+	}
+	return "No trains scheduled right now"
+}
+
+// Formats departures grouped by destination in alphabetical order, under
+// direction headers when the direction of every train is known
+func formatDepartures(deps map[string][]departureInfo, opts renderOptions) string {
+	//	Common after service hours and at terminal stations, so not an error
+	if len(deps) == 0 && !opts.allDirections {
+		return opts.noTrains() + "\n\n"
+	}
+
+	infoStr := stationStats(deps) + "\n\n"
+	if len(deps) == 0 {
+		infoStr = opts.noTrains() + "\n\n"
+	}
+
+	byDirection := departuresByDirection(deps)
+	if byDirection == nil {
+		return infoStr + formatDestinations(deps, opts)
+	}
+
+	//	A fixed layout: both directions, even one with no trains
+	if opts.allDirections {
+		for _, dir := range []string{"North", "South"} {
+			if byDirection[dir] == nil {
+				byDirection[dir] = map[string][]departureInfo{}
+			}
+		}
+	}
+
+	//	Mirror the station signage: "Northbound (Platform 2)", then its trains
+	var directions []string
+	for dir := range byDirection {
+		directions = append(directions, dir)
+	}
+	sort.Strings(directions)
+
+	for _, dir := range directions {
+		body := formatDestinations(byDirection[dir], opts)
+		if body == "" && opts.allDirections {
+			body = "No service this direction\n\n"
+		} else if body == "" {
+			continue
+		}
+		infoStr += opts.theme.Header.Render(opts.directionHeader(dir, byDirection[dir])) + "\n\n" + body
+	}
+	return infoStr
+}
+
+// Splits departures by direction ("North", "South"), or returns nil if any
+// train's direction is unknown
+func departuresByDirection(deps map[string][]departureInfo) map[string]map[string][]departureInfo {
+	byDirection := make(map[string]map[string][]departureInfo)
+	for dest, depList := range deps {
+		for _, dep := range depList {
+			if dep.Direction == "" {
+				return nil
+			}
+			if byDirection[dep.Direction] == nil {
+				byDirection[dep.Direction] = make(map[string][]departureInfo)
+			}
+			byDirection[dep.Direction][dest] = append(byDirection[dep.Direction][dest], dep)
+		}
+	}
+	return byDirection
+}
+
+// A platform number with its label from the config, e.g. "1 (lower)"
+func (o renderOptions) platformName(platform string) string {
+	if label := o.platforms[platform]; label != "" {
+		return platform + " (" + label + ")"
+	}
+	return platform
+}
+
+// Header for a direction's trains, e.g. "Northbound (Platform 2)"
+func (o renderOptions) directionHeader(direction string, deps map[string][]departureInfo) string {
+	seen := make(map[string]bool)
+	var platforms []string
+	for _, depList := range deps {
+		for _, dep := range depList {
+			if dep.Platform != "" && !seen[dep.Platform] {
+				seen[dep.Platform] = true
+				platforms = append(platforms, dep.Platform)
+			}
+		}
+	}
+	sort.Strings(platforms)
+	for i, p := range platforms {
+		platforms[i] = o.platformName(p)
+	}
+
+	header := direction + "bound"
+	switch len(platforms) {
+	case 0:
+	case 1:
+		header += " (Platform " + platforms[0] + ")"
+	default:
+		header += " (Platforms " + strings.Join(platforms, ", ") + ")"
+	}
+	return header
+}
+
+// Formats each destination followed by its trains
+func formatDestinations(deps map[string][]departureInfo, opts renderOptions) string {
+	//	sort the departures in alphabetical order
+	var keys []string
+	for dest := range deps {
+		keys = append(keys, dest)
+	}
+	sort.Strings(keys)
+
+	//	or by each destination's soonest train when sorting by time
+	if opts.sortByTime {
+		soonest := func(dest string) int {
+			if sorted := sortByMinutes(deps[dest]); len(sorted) > 0 {
+				return minutesSortKey(sorted[0].Minutes)
+			}
+			return unknownMinutes
+		}
+		sort.SliceStable(keys, func(i, j int) bool {
+			return soonest(keys[i]) < soonest(keys[j])
+		})
+	}
+
+	var infoStr string
+	for _, dest := range keys {
+		depList := deps[dest]
+		if opts.sortByTime || opts.nextOnly {
+			depList = sortByMinutes(depList)
+		}
+
+		//	Trains merged from ETD blocks in different directions say which way they go
+		mixed := false
+		for _, dep := range depList {
+			if dep.Direction != depList[0].Direction {
+				mixed = true
+			}
+		}
+
+		//	The soonest shown train you can make stands out from the rest
+		next := -1
+		for i, dep := range depList {
+			if !opts.hides(dep) && !opts.missed(dep) && (next < 0 || minutesSortKey(dep.Minutes) < minutesSortKey(depList[next].Minutes)) {
+				next = i
+			}
+		}
+
+		var lines string
+		shown, more := 0, 0
+		for i, dep := range depList {
+			if opts.hides(dep) {
+				continue
+			}
+			if opts.maxPerDest > 0 && shown == opts.maxPerDest {
+				more++
+				continue
+			}
+			shown++
+			line := opts.departureLine(dep)
+			if mixed && dep.Direction != "" && !slices.Contains(opts.columns, "direction") {
+				line += " | " + dep.Direction + "bound"
+			}
+			if marker := opts.lineMarker(dep.Color, dep.HexColor); marker != "" {
+				line = marker + " " + line
+			}
+			switch {
+			case i == next:
+				lines += opts.theme.Next.Render(line) + "\n"
+			case opts.missed(dep):
+				lines += opts.theme.Missed.Render(line) + "\n"
+			case opts.walk > 0:
+				lines += opts.theme.Catchable.Render(line) + "\n"
+			default:
+				lines += opts.theme.Departure.Render(line) + "\n"
+			}
+			if opts.nextOnly {
+				break
+			}
+		}
+		if more > 0 {
+			lines += opts.theme.Departure.Render(fmt.Sprintf("%11s", fmt.Sprintf("+%d more", more))) + "\n"
+		}
+
+		//	Skip destinations whose trains are all filtered out
+		if lines == "" {
+			continue
+		}
+		header := dest + ":"
+		if opts.histogram {
+			header += " " + departureHistogram(depList)
+		}
+		if note := lastTrainsNote(len(deps[dest]), clock()); note != "" && depList[0].Time == "" {
+			header += " " + note
+		}
+		infoStr += header + "\n" + lines + "\n"
+	}
+	return infoStr
+}
+
+// Directory holding the config and state files, e.g. ~/.config/bart-schedule
+func configDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "bart-schedule"), nil
+}
+
+// Location of the state file
+func statePath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "state.json"), nil
+}
+
+// Falls back to $BART_DEFAULT_STATION, then the config's default_station,
+// when no station is given on the command line
+func withDefaultStation(args []string, cfg config) []string {
+	if len(args) > 0 {
+		return args
+	}
+	if station := os.Getenv("BART_DEFAULT_STATION"); station != "" {
+		return []string{station}
+	}
+	if cfg.DefaultStation != "" {
+		return []string{cfg.DefaultStation}
+	}
+	return args
+}
+
+// Loads the config file, returning an empty config if there is none
+func loadConfig() (config, error) {
+	var cfg config
+	dir, err := configDir()
+	if err != nil {
+		return cfg, nil
+	}
+	path := filepath.Join(dir, "config.json")
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Loads the state saved by a previous run
+func loadState() (appState, error) {
+	var state appState
+	path, err := statePath()
+	if err != nil {
+		return state, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+// Saves state for the next run
+func saveState(state appState) error {
+	path, err := statePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Sentinel sort key for minutes that aren't a number, so they sort last
+const unknownMinutes = 1 << 30
+
+// Sort key for an estimate's minutes: "Leaving" is 0, numbers are themselves,
+// and anything else sorts after every real estimate
+func minutesSortKey(minutes string) int {
+	if min, ok := parseMinutes(minutes); ok {
+		return min
+	}
+	return unknownMinutes
+}
+
+// Sorts departures soonest first, keeping API order for equal estimates
+func sortByMinutes(deps []departureInfo) []departureInfo {
+	sorted := append([]departureInfo(nil), deps...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return minutesSortKey(sorted[i].Minutes) < minutesSortKey(sorted[j].Minutes)
+	})
+	return sorted
+}
+
+// Parses a "LAT,LON" location such as "37.7845,-122.4080"
+func parseLocation(value string) (location, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return location{}, fmt.Errorf("expected LAT,LON, got %q", value)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return location{}, fmt.Errorf("invalid latitude in %q", value)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return location{}, fmt.Errorf("invalid longitude in %q", value)
+	}
+	return location{Lat: lat, Lon: lon}, nil
+}
+
+// Station coordinates, if BART provided them
+func (st station) location() (location, bool) {
+	lat, err := strconv.ParseFloat(st.Latitude, 64)
+	if err != nil {
+		return location{}, false
+	}
+	lon, err := strconv.ParseFloat(st.Longitude, 64)
+	if err != nil {
+		return location{}, false
+	}
+	return location{Lat: lat, Lon: lon}, true
+}
+
+// Straight-line (great-circle) distance between two points in kilometers
+func haversineKm(a, b location) float64 {
+	const earthRadiusKm = 6371.0
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := rad(b.Lat - a.Lat)
+	dLon := rad(b.Lon - a.Lon)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(a.Lat))*math.Cos(rad(b.Lat))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+// Rough walking time in whole minutes for a distance at average walking speed
+func walkingMinutes(km float64) int {
+	return int(math.Ceil(km / walkingKmPerHour * 60))
+}
+
+// Distance and walking time from --near to a station, e.g.
+// "0.8 km away, about 10 min walk", or "" if either location is unknown
+func (m model) distanceLine(st station) string {
+	if m.near == nil {
+		return ""
+	}
+	loc, ok := st.location()
+	if !ok {
+		return ""
+	}
+	km := haversineKm(*m.near, loc)
+	return fmt.Sprintf("%.1f km away, about %d min walk\n", km, walkingMinutes(km))
+}
+
+// BART normally estimates this many trains per destination
+const usualEstimates = 3
+
+// Explains a short estimate list late in the evening, when it means service
+// is winding down rather than a problem with the board
+func lastTrainsNote(count int, now time.Time) string {
+	expected := usualEstimates
+	if estimateCount > 0 {
+		expected = estimateCount //	--count asked for a different number
+	}
+	if count == 0 || count >= expected {
+		return ""
+	}
+	if !lateNight(now) {
+		return ""
+	}
+	if count == 1 {
+		return "(last train)"
+	}
+	return "(last trains)"
+}
+
+// Buckets of the next hour shown by departureHistogram
+const (
+	histogramBuckets = 6
+	histogramMinutes = 10
+)
+
+// Compact bar of how many trains leave in each 10-minute slice of the next
+// hour, e.g. "█·▄···", scaled to the busiest slice
+func departureHistogram(deps []departureInfo) string {
+	bars := []rune("▁▂▃▄▅▆▇█")
+
+	var counts [histogramBuckets]int
+	busiest := 0
+	for _, dep := range deps {
+		min, ok := parseMinutes(dep.Minutes)
+		if !ok || min >= histogramBuckets*histogramMinutes {
+			continue
+		}
+		b := min / histogramMinutes
+		counts[b]++
+		busiest = max(busiest, counts[b])
+	}
+
+	var out strings.Builder
+	for _, n := range counts {
+		if n == 0 {
+			out.WriteRune('·')
+			continue
+		}
+		out.WriteRune(bars[(n*len(bars)-1)/busiest])
+	}
+	return out.String()
+}
+
+// Fields that --columns can show for each train
+var departureColumns = []string{"minutes", "platform", "direction", "length", "bike"}
+
+// Parses a --columns list such as "minutes,platform,length"
+func parseColumns(value string) ([]string, error) {
+	var columns []string
+	for _, col := range strings.Split(value, ",") {
+		col = strings.ToLower(strings.TrimSpace(col))
+		if !slices.Contains(departureColumns, col) {
+			return nil, fmt.Errorf("unknown column %q, expected %s", col, strings.Join(departureColumns, ", "))
+		}
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+// One train's fields joined by " | ", e.g. "   in 5 min | Platform 2"
+func (o renderOptions) departureLine(dep departureInfo) string {
+	columns := o.columns
+	if columns == nil {
+		columns = []string{"minutes", "platform"}
+	}
+
+	var fields []string
+	for _, col := range columns {
+		switch col {
+		case "minutes":
+			if dep.Time != "" {
+				fields = append(fields, fmt.Sprintf("%11s", "at "+dep.Time+" (scheduled)"))
+			} else if !o.terse {
+				fields = append(fields, fmt.Sprintf("%11s", o.humanizeMinutes(dep.Minutes)))
+			} else if dep.Minutes == "Leaving" {
+				label := dep.Minutes
+				if o.leaving != "" {
+					label = o.leaving
+				}
+				fields = append(fields, fmt.Sprintf("%8s", label))
+			} else {
+				fields = append(fields, fmt.Sprintf("%8s", dep.Minutes+" "+o.minuteUnit()))
+			}
+		case "platform":
+			if dep.Platform != "" {
+				fields = append(fields, "Platform "+o.platformName(dep.Platform))
+			}
+		case "direction":
+			if dep.Direction != "" {
+				fields = append(fields, dep.Direction+"bound")
+			}
+		case "length":
+			if dep.Length != "" {
+				fields = append(fields, dep.Length+" cars")
+			}
+		case "bike":
+			if dep.BikeFlag == "1" {
+				fields = append(fields, "bikes OK")
+			} else if dep.BikeFlag != "" {
+				fields = append(fields, "no bikes")
+			}
+		}
+	}
+	return strings.Join(fields, " | ")
+}
+
+// Station title above the departures: boxed like an airport departure
+// board, or plain text under --no-color
+func (o renderOptions) title(text string) string {
+	if !o.banner {
+		return text
+	}
+	return lipgloss.NewStyle().
+		Border(lipgloss.DoubleBorder()).
+		BorderForeground(o.theme.Header.GetForeground()).
+		Padding(0, 2).
+		Bold(true).
+		Render(strings.ToUpper(text))
+}
+
+// Short text tags for each BART line color, so lines can be told apart
+// without relying on color
+var lineSymbols = map[string]string{
+	"YELLOW": "Y",
+	"ORANGE": "O",
+	"GREEN":  "G",
+	"RED":    "R",
+	"BLUE":   "B",
+	"PURPLE": "P",
+	"WHITE":  "W",
+	"BEIGE":  "BG",
+}
+
+// Marker for a train's line: a dot in the line's color, tagged like "[Y]"
+// with --symbols; just the tag when colors are off
+func (o renderOptions) lineMarker(color, hex string) string {
+	if color == "" {
+		return ""
+	}
+	tag, ok := lineSymbols[strings.ToUpper(color)]
+	if !ok {
+		tag = strings.ToUpper(color[:1])
+	}
+	tag = "[" + tag + "]"
+
+	c := lineColor(hex, o.colorProfile)
+	if !o.lineColors || c == nil {
+		return tag
+	}
+	dot := o.colorProfile.String("●").Foreground(c).String()
+	if o.symbols {
+		return dot + " " + tag
+	}
+	return dot
+}
+
+// The color a terminal with the given profile can show that is nearest a
+// line's hex color: exact with truecolor, else the closest of the 256 or 16
+// colors. nil without colors or for a malformed hex.
+func lineColor(hex string, profile termenv.Profile) termenv.Color {
+	if !strings.HasPrefix(hex, "#") {
+		return nil
+	}
+	c := profile.Color(hex)
+	if _, ok := c.(termenv.NoColor); ok {
+		return nil
+	}
+	return c
+}
+
+// Parses an estimate's minutes, treating "Leaving" as 0
+func parseMinutes(minutes string) (int, bool) {
+	if minutes == "Leaving" {
+		return 0, true
+	}
+	min, err := strconv.Atoi(minutes)
+	if err != nil {
+		return 0, false
+	}
+	return min, true
+}
+
+// Exit codes, each outcome its own so scripts can tell them apart, e.g.
+// "bart-schedule --exit-code --to dublin POWL && leave"
+const (
+	exitTrainSoon   = 0 //	success; with --exit-code, a matching train leaves within --within minutes
+	exitNoTrainSoon = 1 //	with --exit-code, no matching train leaves that soon
+	exitInvalidKey  = 2 //	the BART API rejected the key
+	exitUnreachable = 3 //	the BART API could not be reached
+	exitUsage       = 4 //	bad flags or arguments
+	exitFetchFailed = 5 //	departures or the schedule could not be fetched
+	exitConfig      = 6 //	unreadable config file or keymap, no API key, or the log file can't be opened
+	exitFailed      = 7 //	the interactive view failed
+)
+
+// Listed after the flags in --help
+const exitCodesHelp = `
+Exit codes:
+  0  success; with --exit-code, a matching train leaves within --within minutes
+  1  with --exit-code, no matching train leaves that soon
+  2  the BART API rejected the key
+  3  the BART API could not be reached
+  4  bad flags or arguments
+  5  departures or the schedule could not be fetched
+  6  unreadable config file or keymap, no API key, or the log file can't be opened
+  7  the interactive view failed
+`
+
+// Exit code for --exit-code: whether a train to a destination containing
+// to (any destination if empty) leaves within the given minutes
+func leaveExitCode(deps map[string][]departureInfo, to string, within int) int {
+	for dest, depList := range deps {
+		if !strings.Contains(strings.ToLower(dest), strings.ToLower(to)) {
+			continue
+		}
+		for _, dep := range depList {
+			if min, ok := parseMinutes(dep.Minutes); ok && min <= within {
+				return exitTrainSoon
+			}
+		}
+	}
+	return exitNoTrainSoon
+}
+
+// Formats the soonest departure per destination on one line each,
+// e.g. "POWL → Dublin/Pleasanton: 4m (Plat 1)", kept under 80 columns
+func compactDepartures(origin string, deps map[string][]departureInfo) []string {
+	var keys []string
+	for dest := range deps {
+		keys = append(keys, dest)
+	}
+	sort.Strings(keys)
+
+	var lines []string
+	for _, dest := range keys {
+		best, bestMin := departureInfo{}, -1
+		for _, dep := range deps[dest] {
+			if min, ok := parseMinutes(dep.Minutes); ok && (bestMin < 0 || min < bestMin) {
+				best, bestMin = dep, min
+			}
+		}
+		if bestMin < 0 {
+			continue
+		}
+
+		line := fmt.Sprintf("%s → %s: %dm", origin, dest, bestMin)
+		if best.Platform != "" {
+			line += fmt.Sprintf(" (Plat %s)", best.Platform)
+		}
+		if runes := []rune(line); len(runes) > 79 {
+			line = string(runes[:78]) + "…"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Writes departures to a timestamped file in the working directory,
+// as plain text ("txt", rendered with opts as on screen) or CSV ("csv"),
+// and returns the file name
+func exportDepartures(deps map[string][]departureInfo, format string, opts renderOptions) (string, error) {
+	name := fmt.Sprintf("bart-departures-%s.%s", clock().Format("20060102-150405"), format)
+
+	var data []byte
+	switch format {
+	case "txt":
+		data = []byte(formatDepartures(deps, opts))
+	case "csv":
+		var keys []string
+		for dest := range deps {
+			keys = append(keys, dest)
+		}
+		sort.Strings(keys)
+
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"destination", "minutes", "platform", "direction"})
+		for _, dest := range keys {
+			for _, dep := range deps[dest] {
+				w.Write([]string{dest, dep.Minutes, dep.Platform, dep.Direction})
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return "", err
+		}
+		data = buf.Bytes()
+	default:
+		return "", fmt.Errorf("unknown export format %q", format)
+	}
+
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// Prints departures for each station as CSV with a header row, for --csv
+func writeCSV(out io.Writer, apiKey string, abbrs []string) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"station", "destination", "minutes", "platform", "direction", "color"})
+	for _, abbr := range abbrs {
+		abbr = strings.ToUpper(abbr)
+		deps, err := getDepartures(apiKey, abbr, "")
+		if err != nil {
+			return fmt.Errorf("%s: %w", abbr, err)
+		}
+
+		var keys []string
+		for dest := range deps {
+			keys = append(keys, dest)
+		}
+		sort.Strings(keys)
+		for _, dest := range keys {
+			for _, dep := range sortByMinutes(deps[dest]) {
+				w.Write([]string{abbr, dest, dep.Minutes, dep.Platform, dep.Direction, dep.Color})
+			}
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// Prints departures for each station as a JSON object per line, for --json
+func writeJSON(out io.Writer, apiKey string, abbrs []string) error {
+	enc := json.NewEncoder(out)
+	for _, abbr := range abbrs {
+		abbr = strings.ToUpper(abbr)
+		deps, err := getDepartures(apiKey, abbr, "")
+		if err != nil {
+			return fmt.Errorf("%s: %w", abbr, err)
+		}
+		for dest, depList := range deps {
+			deps[dest] = sortByMinutes(depList)
+		}
+		if err := enc.Encode(departuresJSON{Station: abbr, Updated: clock().UTC(), Departures: deps}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes departures as JSON every interval until stop is closed, for --json
+// --watch; a failed refresh is reported on stderr and retried next time
+func watchJSON(out io.Writer, apiKey string, abbrs []string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := writeJSON(out, apiKey, abbrs); err != nil {
+			fmt.Fprintf(os.Stderr, "Error fetching departures: %v\n", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Fires a desktop notification using the OS notification command
+func sendNotification(title, body string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", body, title)
+		cmd = exec.Command("osascript", "-e", script)
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.Command("notify-send", title, body)
+	default:
+		return fmt.Errorf("desktop notifications are not supported on %s", runtime.GOOS)
+	}
+	return cmd.Run()
+}
+
+// Copies text to the system clipboard using the OS clipboard command
+func copyToClipboard(text string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("pbcopy")
+	case "windows":
+		cmd = exec.Command("clip")
+	default:
+		//	Prefer Wayland, then fall back to the X11 tools
+		switch {
+		case os.Getenv("WAYLAND_DISPLAY") != "":
+			cmd = exec.Command("wl-copy")
+		case commandExists("xclip"):
+			cmd = exec.Command("xclip", "-selection", "clipboard")
+		case commandExists("xsel"):
+			cmd = exec.Command("xsel", "--clipboard", "--input")
+		default:
+			return errors.New("no clipboard command found (install wl-copy, xclip or xsel)")
+		}
+	}
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+// Opens a URL in the default browser
+func openURL(link string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", link)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", link)
+	default:
+		//	Over SSH or on a server there's no browser to open
+		if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			return errors.New("no browser available (no display)")
+		}
+		if !commandExists("xdg-open") {
+			return errors.New("no browser command found (install xdg-open)")
+		}
+		cmd = exec.Command("xdg-open", link)
+	}
+	//	Don't wait for the browser, just make sure it launched
+	return cmd.Start()
+}
+
+// Google Maps link to a station: its coordinates when known, else a search by name
+func mapsURL(st station) string {
+	query := st.Name + " BART station"
+	if loc, ok := st.location(); ok {
+		query = fmt.Sprintf("%f,%f", loc.Lat, loc.Lon)
+	}
+	return "https://www.google.com/maps/search/?api=1&query=" + url.QueryEscape(query)
+}
+
+// Reports whether an executable is on the PATH
+func commandExists(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// Checks the notify rules in the background and fires one notification per
+// approaching train, returning the trains notified so far
+func (m model) checkNotifications(now time.Time) tea.Cmd {
+	//	Forget trains that have left, so only upcoming ones are remembered
+	notified := make(map[string][]time.Time)
+	for key, arrivals := range m.notified {
+		for _, arrival := range arrivals {
+			if arrival.After(now.Add(-notifyDrift)) {
+				notified[key] = append(notified[key], arrival)
+			}
+		}
+	}
+
+	return func() tea.Msg {
+		for _, rule := range m.notify {
+			notifyRuleTrains(m.api_key, rule, notified, now)
+		}
+		return notifiedMsg{notified: notified}
+	}
+}
+
+// Estimates drift as trains approach, so arrivals this close to an earlier
+// notification are taken to be the same train
+const notifyDrift = 2 * time.Minute
+
+// Message carrying the trains notified so far (from checkNotifications)
+type notifiedMsg struct {
+	notified map[string][]time.Time
+}
+
+// Fires a notification for each train matching rule that is due within its
+// minutes, skipping and recording trains already in notified
+func notifyRuleTrains(apiKey string, rule notifyRule, notified map[string][]time.Time, now time.Time) {
+	deps, err := getDepartures(apiKey, rule.Station, "")
+	if err != nil {
+		return
+	}
+
+	key := fmt.Sprintf("%s:%s", rule.Station, strings.ToLower(rule.Destination))
+	for dest, depList := range deps {
+		if !strings.Contains(strings.ToLower(dest), strings.ToLower(rule.Destination)) {
+			continue
+		}
+		for _, dep := range depList {
+			min, ok := parseMinutes(dep.Minutes)
+			if !ok || min > rule.Minutes {
+				continue
+			}
+
+			arrival := now.Add(time.Duration(min) * time.Minute)
+			seen := false
+			for _, t := range notified[key] {
+				if d := arrival.Sub(t); d > -notifyDrift && d < notifyDrift {
+					seen = true
+					break
+				}
+			}
+			if seen {
+				continue
+			}
+
+			notified[key] = append(notified[key], arrival)
+			notifyFunc(
+				fmt.Sprintf("BART %s → %s", rule.Station, dest),
+				fmt.Sprintf("Train leaves in %d min from platform %s", min, dep.Platform),
+			)
+		}
+	}
+}
+
+// Abbreviation of the station being shown, or the one restored at startup
+func (m model) currentAbbr() string {
+	if m.viewing.Abbr != "" {
+		return m.viewing.Abbr
+	}
+	if m.locked() {
+		return strings.ToUpper(m.args[0])
+	}
+	return m.lastStation
+}
+
+// Saves the current station, direction and sort orders for the next run
+func (m model) persist() {
+	if m.remember {
+		var stationSort string
+		if m.stationSort != 0 {
+			stationSort = stationSorts[m.stationSort]
+		}
+		saveState(appState{LastStation: m.currentAbbr(), Direction: m.direction, SortByTime: m.sortByTime, Favorites: m.favorites, StationSort: stationSort})
+	}
+}
+
+// Whether a station is starred
+func (m model) isFavorite(abbr string) bool {
+	return slices.Contains(m.favorites, strings.ToUpper(abbr))
+}
+
+// Stars or unstars a station and saves the favorites, even with --no-restore
+func (m model) toggleFavorite(abbr string) model {
+	abbr = strings.ToUpper(abbr)
+	if i := slices.Index(m.favorites, abbr); i >= 0 {
+		m.favorites = slices.Delete(slices.Clone(m.favorites), i, i+1)
+		m.status = "Removed " + abbr + " from favorites"
+	} else {
+		m.favorites = append(slices.Clone(m.favorites), abbr)
+		m.status = "Added " + abbr + " to favorites"
+	}
+
+	if m.remember {
+		m.persist()
+	} else {
+		state, _ := loadState()
+		state.Favorites = m.favorites
+		saveState(state)
+	}
+	return m
+}
+
+// Shows a favorite's departures from any view: in the station list by
+// moving the cursor onto it, or in place of the locked station
+func (m model) jumpToFavorite(abbr string) (model, tea.Cmd) {
+	m.showAdvisories, m.showRoutes = false, false
+	m.board, m.boardDeps, m.boardErrs, m.boardUpdated = nil, nil, nil, nil
+
+	if m.locked() {
+		m.args = []string{abbr}
+		m.selectedName = ""
+		m.departures = nil
+		return m, m.fetchLocked(station{Abbr: abbr})
+	}
+
+	m.filter = ""
+	for i, st := range m.visibleStations() {
+		if strings.EqualFold(st.Abbr, abbr) {
+			m.cursor = i
+			return m.selectStation(st)
+		}
+	}
+	m.status = abbr + " is not in the station list"
+	return m, nil
+}
+
+// In auto-follow mode, once a station is being viewed, schedules a fetch for
+// the station under the cursor after it rests for followDelay
+func (m model) followCursor() (model, tea.Cmd) {
+	if !m.follow || m.viewing.Abbr == "" {
+		return m, nil
+	}
+	m.followSeq++
+	seq := m.followSeq
+	return m, tea.Tick(followDelay, func(time.Time) tea.Msg {
+		return followMsg{seq: seq}
+	})
+}
+
+// Starts fetching departures for a station picked from the list, showing a
+// placeholder until they arrive so the list stays interactive
+func (m model) selectStation(selected station) (model, tea.Cmd) {
+	m.loading = selected
+	return m, tea.Batch(m.fetchDepartures(selected), m.spinner.Tick)
+}
+
+// Fetch a station's departures as a command for Update()
+func (m model) fetchDepartures(st station) tea.Cmd {
+	return func() tea.Msg {
+		deps, err := m.departuresFor(st.Abbr)
+		return departuresMsg{station: st, deps: deps, err: err}
+	}
+}
+
+// Message carrying a station's first train once its service has ended
+// (from fetchFirstTrain)
+type firstTrainMsg struct {
+	abbr  string
+	first time.Time
+}
+
+// Keeps a station's first-train note in step with fetched departures: an
+// empty board late at night looks the first train up in the background,
+// anything else forgets it
+func (m model) noteServiceEnd(abbr string, deps map[string][]departureInfo) (model, tea.Cmd) {
+	now := clock()
+	if len(deps) > 0 || !lateNight(now) {
+		return m.withFirstTrain(abbr, time.Time{}), nil
+	}
+	if m.firstTrains[strings.ToUpper(abbr)].After(now) {
+		return m, nil
+	}
+	return m.withFirstTrain(abbr, time.Time{}), m.fetchFirstTrain(abbr)
+}
+
+// Looks up the first train from abbr once the live feed shows no trains in
+// any direction
+func (m model) fetchFirstTrain(abbr string) tea.Cmd {
+	return func() tea.Msg {
+		msg := firstTrainMsg{abbr: strings.ToUpper(abbr)}
+		//	A board showing one direction can be empty while the other runs
+		if m.direction != "" {
+			if live, err := getDepartures(m.api_key, abbr, ""); err != nil || len(live) > 0 {
+				return msg
+			}
+		}
+		first, err := firstScheduledTrain(m.api_key, abbr, clock())
+		if err != nil {
+			logger.Error("fetching first train", "station", abbr, "err", err)
+		}
+		msg.first = first
+		return msg
+	}
+}
+
+// Records a station's first train after service, or forgets it when zero
+func (m model) withFirstTrain(abbr string, first time.Time) model {
+	abbr = strings.ToUpper(abbr)
+	if first.Equal(m.firstTrains[abbr]) {
+		return m
+	}
+	m.firstTrains = maps.Clone(m.firstTrains)
+	if first.IsZero() {
+		delete(m.firstTrains, abbr)
+	} else {
+		if m.firstTrains == nil {
+			m.firstTrains = make(map[string]time.Time)
+		}
+		m.firstTrains[abbr] = first
+	}
+	return m
+}
+
+// A station's board: its name, distance and departures
+func (m model) stationInfo(st station, deps map[string][]departureInfo) string {
+	return m.title(st.Name) + "\n" + m.distanceLine(st) + "\n" + formatDepartures(deps, m.optionsFor(st.Abbr))
+}
+
+// Shows departures fetched for a station picked from the list
+func (m model) showStation(selected station, deps map[string][]departureInfo, err error) (model, tea.Cmd) {
+	if err != nil {
+		m.info = fmt.Sprintf("Error fetching departures: %v", err)
+		return m, nil
+	}
+
+	//	Format the departure info
+	m.viewing = selected
+	m.departures = deps
+	m.info = m.stationInfo(selected, deps)
+
+	//	Remember the station for the next run
+	m.persist()
+
+	//	Look up arrivals at the --arrive destination from this station
+	m.arrivals = nil
+	if m.arriveAt != "" {
+		return m, fetchArrivals(m.api_key, selected.Abbr, m.arriveAt)
+	}
+	return m, nil
+}
+
+// Render options for a station's departures, with its platform labels
+func (m model) optionsFor(abbr string) renderOptions {
+	opts := m.renderOptions
+	opts.platforms = m.platformLabels[strings.ToUpper(abbr)]
+	opts.firstTrain = m.firstTrains[strings.ToUpper(abbr)]
+	return opts
+}
+
+// Live departures from a station, followed by scheduled ones with --with-scheduled
+func (m model) departuresFor(abbr string) (map[string][]departureInfo, error) {
+	deps, err := getDepartures(m.api_key, abbr, m.direction)
+	if err != nil || !m.withScheduled {
+		return deps, err
+	}
+	now := clock()
+	items, err := m.schedules.get(m.api_key, strings.ToUpper(abbr), serviceDay(now))
+	if err != nil {
+		logger.Error("fetching scheduled departures", "station", abbr, "err", err)
+		return deps, nil
+	}
+	return mergeScheduled(deps, scheduledWithin(items, now), m.stationNames), nil
+}
+
+// Fetches departures for every board station as a command for Update()
+func (m model) fetchBoard() tea.Cmd {
+	if len(m.board) == 0 {
+		return nil
+	}
+	apiKey, board, direction := m.api_key, m.board, m.direction
+	return func() tea.Msg {
+		msg := boardMsg{
+			deps: make(map[string]map[string][]departureInfo),
+			errs: make(map[string]error),
+		}
+		for _, abbr := range board {
+			deps, err := getDepartures(apiKey, abbr, direction)
+			if err != nil {
+				msg.errs[abbr] = err
+				continue
+			}
+			msg.deps[abbr] = deps
+		}
+		return msg
+	}
+}
+
+// Station list orders cycled with g: as the API lists them, by name, by
+// abbreviation, or grouped by city
+var stationSorts = []string{"as listed", "name", "abbreviation", "city"}
+
+// Orders the station list by one of the stationSorts, keeping the cursor on
+// its station
+func (m model) sortStations(sort int) model {
+	var current string
+	if visible := m.visibleStations(); m.cursor < len(visible) {
+		current = visible[m.cursor].Abbr
+	}
+	m.stationSort = sort
+	for i, st := range m.visibleStations() {
+		if st.Abbr == current {
+			m.cursor = i
+		}
+	}
+	return m
+}
+
+// Stations shown in the list, narrowed by the search filter and in the
+// chosen order
+func (m model) visibleStations() []station {
+	visible := m.stations
+	if m.filter != "" {
+		query := strings.ToLower(m.filter)
+		visible = nil
+		for _, st := range m.stations {
+			if strings.Contains(strings.ToLower(st.Name), query) || strings.Contains(strings.ToLower(st.Abbr), query) {
+				visible = append(visible, st)
+			}
+		}
+	}
+
+	switch stationSorts[m.stationSort] {
+	case "name":
+		visible = slices.Clone(visible)
+		sort.SliceStable(visible, func(i, j int) bool { return visible[i].Name < visible[j].Name })
+	case "abbreviation":
+		visible = slices.Clone(visible)
+		sort.SliceStable(visible, func(i, j int) bool { return visible[i].Abbr < visible[j].Abbr })
+	case "city":
+		visible = slices.Clone(visible)
+		sort.SliceStable(visible, func(i, j int) bool {
+			if visible[i].City != visible[j].City {
+				return visible[i].City < visible[j].City
+			}
+			return visible[i].Name < visible[j].Name
+		})
+	}
+	return visible
+}
+
+// Stations an argument refers to: the station with that abbreviation, else
+// the one whose name matches exactly, else every name containing it
+func matchStations(stations []station, arg string) []station {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return nil
+	}
+	for _, st := range stations {
+		if strings.EqualFold(st.Abbr, arg) {
+			return []station{st}
+		}
+	}
+	for _, st := range stations {
+		if strings.EqualFold(st.Name, arg) {
+			return []station{st}
+		}
+	}
+	var matches []station
+	query := strings.ToLower(arg)
+	for _, st := range stations {
+		if st.Abbr != "" && strings.Contains(strings.ToLower(st.Name), query) {
+			matches = append(matches, st)
+		}
+	}
+	return matches
+}
+
+// Keeps the cursor in range after the filter text changes and, with
+// --auto-select, shows departures once only one station matches
+func (m model) filterChanged() (model, tea.Cmd) {
+	m = m.clampCursor()
+	if visible := m.visibleStations(); m.autoSelect && len(visible) == 1 {
+		m.filtering = false
+		return m.selectStation(visible[0])
+	}
+	return m, nil
+}
+
+// Rest of the shortest station abbreviation starting with the filter text,
+// e.g. "WL" for "PO", or "" if nothing longer matches
+func (m model) completion() string {
+	if m.filter == "" {
+		return ""
+	}
+	prefix := strings.ToUpper(m.filter)
+	var best string
+	for _, st := range m.stations {
+		abbr := strings.ToUpper(st.Abbr)
+		if len(abbr) <= len(prefix) || !strings.HasPrefix(abbr, prefix) {
+			continue
+		}
+		if best == "" || len(abbr) < len(best) || (len(abbr) == len(best) && abbr < best) {
+			best = abbr
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return best[len(prefix):]
+}
+
+// Keeps the cursor within the visible stations after the list changes
+func (m model) clampCursor() model {
+	if n := len(m.visibleStations()); m.cursor >= n {
+		m.cursor = max(n-1, 0)
+	}
+	return m
+}
+
+// Reports whether the view is locked to the station given in args
+func (m model) locked() bool {
+	return len(m.args) > 0 && m.stations == nil
+}
+
+// Whether the tick is refreshing what's on screen: a locked station or a
+// board, while the terminal is focused
+func (m model) autoRefreshing() bool {
+	return (m.locked() || len(m.board) > 0) && !m.blurred && m.once == 0
+}
+
+// Re-fetches the displayed departures (a board's, the locked station's or
+// the one being viewed) without touching the station list
+func (m model) reloadDepartures() (model, tea.Cmd) {
+	if len(m.board) > 0 {
+		return m, m.fetchBoard()
+	}
+	if m.locked() {
+		return m, m.fetchLocked(station{Abbr: m.args[0]})
+	}
+	if m.viewing.Abbr != "" {
+		return m.selectStation(m.viewing)
+	}
+	return m, nil
+}
+
+// Message carrying departures for the station locked in by args (from
+// fetchLocked)
+type lockedMsg struct {
+	station station
+	deps    map[string][]departureInfo
+	err     error
+}
+
+// Fetches departures for the locked station in the background. st is the
+// station as listed when it was first locked, for its distance; refreshes
+// only know its abbreviation.
+func (m model) fetchLocked(st station) tea.Cmd {
+	st.Abbr = strings.ToUpper(st.Abbr)
+	return func() tea.Msg {
+		deps, err := m.departuresFor(st.Abbr)
+		return lockedMsg{station: st, deps: deps, err: err}
+	}
+}
+
+// Shows fetched departures for the station locked in by args
+func (m model) showLocked(msg lockedMsg) model {
+	stationAbbr := msg.station.Abbr
+	if msg.err != nil {
+		logger.Error("refreshing departures", "station", stationAbbr, "err", msg.err)
+		//	Keep showing the last good departures through a transient failure
+		if m.departures != nil {
+			m.stale = true
+			return m
+		}
+		verb := "refreshing"
+		if msg.station.Name != "" {
+			verb = "fetching"
+		}
+		m.info = fmt.Sprintf("Error %s departures for %s: %v", verb, stationAbbr, msg.err)
+		return m
+	}
+
+	m.stale = false
+	m.lastUpdate = time.Now()
+	if !reflect.DeepEqual(msg.deps, m.departures) {
+		//	Only rebuild the board when the departures actually changed
+		m.departures = msg.deps
+		m.info = m.lockedInfo(msg.station, msg.deps)
+	}
+	return m
+}
+
+// The locked station's board: its name, distance and departures
+func (m model) lockedInfo(st station, deps map[string][]departureInfo) string {
+	displayName := st.Abbr
+	if m.selectedName != "" {
+		displayName = m.selectedName
+	}
+	return m.title(displayName+" Departures") + "\n" + m.distanceLine(st) + "\n" + formatDepartures(deps, m.optionsFor(st.Abbr))
+}
+
+// Handles user input and incoming messages
+func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+
+	//	Handles keypresses
+	case tea.KeyMsg:
+		//	With --once, any key dismisses the departures
+		if m.once > 0 && m.locked() {
+			return m, tea.Quit
+		}
+
+		//	Any other key cancels a pending quit
+		if !m.quitPending.IsZero() && m.keyMap().action(msg) != "quit" {
+			m.quitPending = time.Time{}
+			m.status = ""
+		}
+
+		//	While the search box is open, typing edits the filter
+		if m.filtering {
+			switch msg.Type {
+			case tea.KeyCtrlC:
+				return m, tea.Quit
+			case tea.KeyEsc:
+				m.filtering = false
+				m.filter = ""
+				return m.clampCursor(), nil
+			case tea.KeyEnter:
+				m.filtering = false
+				if visible := m.visibleStations(); len(visible) > 0 {
+					return m.selectStation(visible[m.cursor])
+				}
+				return m, nil
+			case tea.KeyTab:
+				//	Accept the suggested abbreviation
+				if suffix := m.completion(); suffix != "" {
+					m.filter = strings.ToUpper(m.filter) + suffix
+				}
+				return m.filterChanged()
+			case tea.KeyBackspace:
+				if runes := []rune(m.filter); len(runes) > 0 {
+					m.filter = string(runes[:len(runes)-1])
+				}
+				return m.filterChanged()
+			case tea.KeyRunes, tea.KeySpace:
+				m.filter += string(msg.Runes)
+				return m.filterChanged()
+			}
+		}
+
+		//	Rebindable keys are matched by action, everything else by key
+		pressed := msg.String()
+		if action := m.keyMap().action(msg); action != "" {
+			pressed = action
+		}
+
+		switch pressed {
+		case "search":
+			//	Open the search box
+			if len(m.stations) > 0 {
+				m.filtering = true
+			}
+			return m, nil
+		case "quit":
+			//	With --confirm-quit, 'q' only quits when pressed twice in a row
+			if m.confirmQuit && msg.String() != "ctrl+c" {
+				if m.quitPending.IsZero() || time.Since(m.quitPending) > quitConfirmWindow {
+					m.quitPending = time.Now()
+					m.status = fmt.Sprintf("Press %s again to quit", keyHint(m.keyMap().Quit))
+					return m, nil
+				}
+			}
+			return m, tea.Quit
+		case "up":
+			if m.showAdvisories {
+				if m.advisoryScroll > 0 {
+					m.advisoryScroll--
+				}
+				return m, nil
+			}
+			if m.showRoutes {
+				if m.routeCursor > 0 {
+					m.routeCursor--
+					m.routeStops = nil
+				}
+				return m, nil
+			}
+			if m.cursor > 0 {
 				m.cursor-- //	Move cursor up
+			} else if n := len(m.visibleStations()); m.wrapCursor && n > 0 {
+				m.cursor = n - 1
+			}
+			return m.followCursor()
+		case "down":
+			if m.showAdvisories {
+				if m.advisoryScroll < len(m.advisoryLines())-1 {
+					m.advisoryScroll++
+				}
+				return m, nil
+			}
+			if m.showRoutes {
+				if m.routeCursor < len(m.routes)-1 {
+					m.routeCursor++
+					m.routeStops = nil
+				}
+				return m, nil
+			}
+			if m.cursor < len(m.visibleStations())-1 {
+				m.cursor++ //	Move cursor down
+			} else if m.wrapCursor {
+				m.cursor = 0
+			}
+			return m.followCursor()
+		case "refresh":
+			//	Refresh station list, coming back to the same station
+			if visible := m.visibleStations(); m.cursor < len(visible) {
+				m.keepCursor = visible[m.cursor].Abbr
+			}
+			m.message = "\nRefreshing stations..."
+			m.cursor = 0
+			m.stations = nil
+			m.info = ""
+			m.departures = nil
+			m.viewing = station{}
+			return m, fetchStations(m.api_key)
+		case " ":
+			//	Refresh only the displayed departures, keeping the list as it is
+			return m.reloadDepartures()
+		case "+":
+			//	In the list, mark or unmark the highlighted station for a board
+			if len(m.stations) > 0 && len(m.board) == 0 {
+				if visible := m.visibleStations(); m.cursor < len(visible) {
+					abbr := strings.ToUpper(visible[m.cursor].Abbr)
+					if m.selected == nil {
+						m.selected = make(map[string]bool)
+					}
+					if m.selected[abbr] {
+						delete(m.selected, abbr)
+					} else {
+						m.selected[abbr] = true
+					}
+					m.status = fmt.Sprintf("%d stations marked, press b for a board", len(m.selected))
+				}
+			}
+			return m, nil
+		case "b", "B":
+			//	Show every marked station together on a board
+			if len(m.selected) == 0 {
+				m.status = "Mark stations with + first"
+				return m, nil
+			}
+			m.board = nil
+			for _, st := range m.stations {
+				if abbr := strings.ToUpper(st.Abbr); m.selected[abbr] {
+					m.board = append(m.board, abbr)
+				}
+			}
+			m.boardDeps, m.boardErrs, m.boardUpdated = nil, nil, nil
+			return m, m.fetchBoard()
+		case "d", "D":
+			//	Cycle the requested direction: both → north → south → both
+			switch m.direction {
+			case "":
+				m.direction, m.status = "n", "Showing northbound trains"
+			case "n":
+				m.direction, m.status = "s", "Showing southbound trains"
+			default:
+				m.direction, m.status = "", "Showing trains in both directions"
+			}
+			m.persist()
+			m.departures = nil
+			return m.reloadDepartures()
+		case "m", "M":
+			//	Toggle hiding trains that leave sooner than --min-minutes
+			if m.minMinutes == 0 {
+				m.status = "No --min-minutes filter set"
+				return m, nil
+			}
+			m.showAll = !m.showAll
+			if m.showAll {
+				m.status = "Showing all trains"
+			} else {
+				m.status = fmt.Sprintf("Hiding trains leaving in under %d min", m.minMinutes)
+			}
+			m.departures = nil
+			return m.reloadDepartures()
+		case "esc":
+			if m.showAdvisories {
+				m.showAdvisories = false
+				return m, nil
+			}
+			if m.showRoutes {
+				m.showRoutes = false
+				return m, nil
+			}
+			//	Clear a search that is still narrowing the list
+			if m.filter != "" {
+				m.filter = ""
+				return m.clampCursor(), nil
+			}
+			//	Leave the board for the station list
+			if len(m.board) > 0 {
+				m.board = nil
+				m.boardDeps = nil
+				m.boardErrs = nil
+				m.boardUpdated = nil
+				return m, nil
+			}
+			//	Leave the locked single-station view for the full station list,
+			//	keeping the cursor on the station that was locked
+			if m.locked() {
+				m.lastStation = strings.ToUpper(m.args[0])
+				m.args = nil
+				m.selectedName = ""
+				m.info = ""
+				m.departures = nil
+				m.message = "\nLoading Bart stations..."
+				return m, tea.Batch(fetchStations(m.api_key), m.spinner.Tick)
+			}
+			return m, nil
+		case "t", "T":
+			//	Toggle sorting departures by time instead of destination name
+			m.sortByTime = !m.sortByTime
+			if m.sortByTime {
+				m.status = "Sorting departures by time"
+			} else {
+				m.status = "Sorting departures by destination"
+			}
+			m.persist()
+			m.departures = nil
+			return m.reloadDepartures()
+		case "n", "N":
+			//	Toggle showing only the next train for each destination
+			m.nextOnly = !m.nextOnly
+			if m.nextOnly {
+				m.status = "Showing the next train only"
+			} else {
+				m.status = "Showing all upcoming trains"
+			}
+			m.departures = nil
+			return m.reloadDepartures()
+		case "*":
+			//	Star or unstar the highlighted station
+			if visible := m.visibleStations(); m.cursor < len(visible) {
+				return m.toggleFavorite(visible[m.cursor].Abbr), nil
 			}
 			return m, nil
-		case "down", "s", "S":
-			if m.cursor < len(m.stations)-1 {
-				m.cursor++ //	Move cursor down
+		case "1", "2", "3", "4", "5", "6", "7", "8", "9":
+			//	Jump to the favorite with that number
+			n := int(pressed[0] - '0')
+			if n > len(m.favorites) {
+				m.status = fmt.Sprintf("No favorite %d, star stations with *", n)
+				return m, nil
+			}
+			return m.jumpToFavorite(m.favorites[n-1])
+		case "h", "H":
+			//	Toggle the trains-per-10-minutes bars
+			m.histogram = !m.histogram
+			if m.histogram {
+				m.status = "Showing trains per 10 minutes"
+			} else {
+				m.status = "Hiding train frequency"
+			}
+			m.departures = nil
+			return m.reloadDepartures()
+		case "x", "X":
+			//	Reset the direction and sort orders to their defaults
+			m.direction, m.sortByTime = "", false
+			m = m.sortStations(0)
+			m.status = "Reset view to defaults"
+			m.persist()
+			m.departures = nil
+			return m.reloadDepartures()
+		case "g", "G":
+			//	Cycle the station list order
+			m = m.sortStations((m.stationSort + 1) % len(stationSorts))
+			m.status = "Stations sorted " + stationSorts[m.stationSort]
+			if m.stationSort != 0 {
+				m.status = "Stations sorted by " + stationSorts[m.stationSort]
+			}
+			m.persist()
+			return m, nil
+		case "f", "F":
+			//	Toggle auto-follow: departures track the cursor as it moves
+			m.follow = !m.follow
+			if m.follow {
+				m.status = "Following the cursor"
+			} else {
+				m.status = "Stopped following the cursor"
+			}
+			return m, nil
+		case "a", "A":
+			//	Toggle the full-screen advisories, fetching them fresh each time
+			m.showAdvisories = !m.showAdvisories
+			if m.showAdvisories {
+				m.advisories = nil
+				m.advisoryScroll = 0
+				return m, fetchAdvisories(m.api_key)
+			}
+			return m, nil
+		case "l", "L":
+			//	Toggle the route (line) list
+			m.showRoutes = !m.showRoutes
+			if m.showRoutes && m.routes == nil {
+				return m, fetchRoutes(m.api_key)
+			}
+			return m, nil
+		case "e", "E", "c", "C":
+			//	Export the displayed departures as text ('e') or CSV ('c')
+			if m.departures == nil {
+				m.status = "No departures to export"
+				return m, nil
+			}
+			format := "txt"
+			if strings.EqualFold(msg.String(), "c") {
+				format = "csv"
+			}
+			name, err := exportDepartures(m.departures, format, m.optionsFor(m.currentAbbr()))
+			if err != nil {
+				m.status = fmt.Sprintf("Export failed: %v", err)
+			} else {
+				m.status = "Departures exported to " + name
+			}
+			return m, nil
+		case "i", "I":
+			//	Save the next planned trip as a calendar event
+			if len(m.arrivals) == 0 {
+				m.status = "No trip to export, use --arrive to plan one"
+				return m, nil
+			}
+			t := m.arrivals[0]
+			name := fmt.Sprintf("bart-trip-%s-%s-%s.ics", t.Origin, t.Destination, clock().Format("20060102-150405"))
+			if err := os.WriteFile(name, tripToICS(t), 0644); err != nil {
+				m.status = fmt.Sprintf("Calendar export failed: %v", err)
+			} else {
+				m.status = "Trip saved to " + name
+			}
+			return m, nil
+		case "y", "Y":
+			//	Copy the selected station's abbreviation ('y') or departures ('Y')
+			var text, what string
+			if msg.String() == "Y" {
+				if m.departures == nil {
+					m.status = "No departures to copy"
+					return m, nil
+				}
+				text, what = formatDepartures(m.departures, renderOptions{terse: m.terse}), "departures"
+			} else if visible := m.visibleStations(); len(visible) > 0 {
+				text = visible[m.cursor].Abbr
+				what = text
+			} else if len(m.args) > 0 {
+				text = strings.ToUpper(m.args[0])
+				what = text
+			} else {
+				return m, nil
+			}
+			if err := clipboardFunc(text); err != nil {
+				m.status = fmt.Sprintf("Copy failed: %v", err)
+			} else {
+				m.status = "Copied " + what
+			}
+			return m, nil
+		case "o", "O":
+			//	Open the selected station on a map, e.g. for walking directions
+			var st station
+			if visible := m.visibleStations(); len(visible) > 0 {
+				st = visible[m.cursor]
+			} else if m.locked() && m.selectedName != "" {
+				st = station{Name: m.selectedName, Abbr: strings.ToUpper(m.args[0])}
+			} else {
+				return m, nil
+			}
+			if err := openURLFunc(mapsURL(st)); err != nil {
+				m.status = fmt.Sprintf("Couldn't open a map: %v", err)
+			} else {
+				m.status = "Opened " + st.Name + " in your browser"
 			}
 			return m, nil
-		case "r", "R":
-			//	Refresh station list
-			m.message = "\nRefreshing stations..."
-			m.cursor = 0
-			m.stations = nil
-			m.info = ""
-			return m, fetchStations(m.api_key)
 		case "enter":
-			//	Show departures for the selected station
-			if len(m.stations) > 0 {
-				selected := m.stations[m.cursor]
-				deps, err := getDepartures(m.api_key, selected.Abbr)
+			//	Show the stations served by the selected route
+			if m.showRoutes {
+				if len(m.routes) == 0 {
+					return m, nil
+				}
+				num, err := strconv.Atoi(m.routes[m.routeCursor].Number)
 				if err != nil {
-					m.info = fmt.Sprintf("Error fetching departures: %v", err)
+					m.info = fmt.Sprintf("Invalid route number %q", m.routes[m.routeCursor].Number)
 					return m, nil
 				}
+				return m, fetchRouteInfo(m.api_key, num)
+			}
+
+			//	Show departures for the selected station
+			if visible := m.visibleStations(); len(visible) > 0 {
+				return m.selectStation(visible[m.cursor])
+			}
+			return m, nil
+		}
+
+	//	Handles departures for a station picked from the list (from fetchDepartures)
+	case departuresMsg:
+		//	Ignore a slow response for a station the user has already moved on from
+		if msg.station.Abbr != m.loading.Abbr {
+			return m, nil
+		}
+		m.loading = station{}
+		var lookup tea.Cmd
+		if msg.err == nil {
+			m, lookup = m.noteServiceEnd(msg.station.Abbr, msg.deps)
+		}
+		m, cmd := m.showStation(msg.station, msg.deps, msg.err)
+		return m, tea.Batch(cmd, lookup)
+
+	//	Notes the first train on an empty board (from fetchFirstTrain)
+	case firstTrainMsg:
+		if msg.first.IsZero() {
+			return m, nil
+		}
+		m = m.withFirstTrain(msg.abbr, msg.first)
+		if m.locked() && strings.EqualFold(m.args[0], msg.abbr) {
+			m.info = m.lockedInfo(station{Abbr: msg.abbr}, m.departures)
+		} else if strings.EqualFold(m.viewing.Abbr, msg.abbr) {
+			m.info = m.stationInfo(m.viewing, m.departures)
+		}
+		return m, nil
+
+	//	Animates the loading placeholder, only while something is loading
+	case spinner.TickMsg:
+		if (m.loading.Abbr == "" && !m.startingUp()) || len(m.spinner.Spinner.Frames) == 0 {
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
+
+	//	Handles terminal resizes
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		return m, nil
+
+	//	Handles message containing routes (from fetchRoutes)
+	case routesMsg:
+		if msg.err != nil {
+			m.info = fmt.Sprintf("Error fetching routes: %v", msg.err)
+			m.showRoutes = false
+			return m, nil
+		}
+		m.routes = msg.routes
+		return m, nil
+
+	//	The cursor stopped moving: show departures for where it ended up
+	case followMsg:
+		if !m.follow || msg.seq != m.followSeq || m.locked() {
+			return m, nil
+		}
+		visible := m.visibleStations()
+		if m.cursor >= len(visible) || visible[m.cursor].Abbr == m.viewing.Abbr {
+			return m, nil
+		}
+		return m.selectStation(visible[m.cursor])
+
+	//	Handles message containing service advisories (from fetchAdvisories)
+	case advisoriesMsg:
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Error fetching advisories: %v", msg.err)
+			m.showAdvisories = false
+			return m, nil
+		}
+		m.advisories = msg.advisories
+		if m.advisories == nil {
+			//	An empty or missing bsa decodes to nil, which means loading
+			m.advisories = []advisory{}
+		}
+		return m, nil
+
+	//	Handles message containing a route's stations (from fetchRouteInfo)
+	case routeInfoMsg:
+		if msg.err != nil {
+			m.info = fmt.Sprintf("Error fetching route info: %v", msg.err)
+			return m, nil
+		}
+		m.routeStops = msg.stops
+		if m.experimentalMap {
+			return m, fetchTrainMap(m.api_key)
+		}
+		return m, nil
+
+	//	Handles message containing every station's departures (from fetchTrainMap)
+	case trainMapMsg:
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Error fetching train positions: %v", msg.err)
+			return m, nil
+		}
+		m.trainMap = msg.byStation
+		return m, nil
+
+	//	Handles message containing scheduled arrivals (from fetchArrivals)
+	case arrivalsMsg:
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Error fetching arrivals: %v", msg.err)
+			return m, nil
+		}
+		m.arrivals = msg.trips
+		return m, nil
+
+	//	Handles message containing stations (from fetchStations)
+	case []station:
+		m.err = nil
+		m.stations = msg
+		m.stationNames = make(map[string]string)
+		for _, st := range msg {
+			m.stationNames[strings.ToUpper(st.Abbr)] = st.Name
+		}
+		m.message = "\nLive Tracking\n============="
+
+		//	Put the cursor back on the station it was on before a reload, if
+		//	that station is still listed
+		reloaded := m.keepCursor != ""
+		if reloaded {
+			for i, st := range m.visibleStations() {
+				if strings.EqualFold(st.Abbr, m.keepCursor) {
+					m.cursor = i
+				}
+			}
+			m.keepCursor = ""
+		}
+
+		//	If the user provided an argument, skip the list and show departures directly
+		var cmd tea.Cmd
+		if len(m.args) > 0 {
+			//	Several stations match the name: let the user pick from them
+			matches := matchStations(m.stations, m.args[0])
+			if len(matches) > 1 {
+				m.filter = m.args[0]
+				m.args = nil
+				m.status = fmt.Sprintf("%d stations match %q", len(matches), m.filter)
+				return m.clampCursor(), nil
+			}
+			if len(matches) == 1 {
+				m.args[0] = matches[0].Abbr
+			}
+
+			stationAbbr := strings.ToUpper(m.args[0])
+			for _, st := range m.stations {
+				if st.Abbr != "" && strings.EqualFold(st.Abbr, stationAbbr) {
+					//	Save the station name
+					m.selectedName = st.Name
+					//	fetch departures immediately
+					m.info = loadingMessage(st.Name)
+					cmd = m.fetchLocked(st)
+					if m.arriveAt != "" {
+						cmd = tea.Batch(cmd, fetchArrivals(m.api_key, st.Abbr, m.arriveAt))
+					}
+
+					// Clear stations so the station list doesn't render
+					m.stations = nil
+					//	Remember the station for the next run, now that it's the locked one
+					m.persist()
+					break
+				}
+			}
+		} else if m.lastStation != "" && !reloaded {
+			//	No argument: restore the station viewed last time, unless this
+			//	is a reload that already put the cursor back
+			for i, st := range m.visibleStations() {
+				if strings.EqualFold(st.Abbr, m.lastStation) {
+					m.cursor = i
+					return m.selectStation(st)
+				}
+			}
+		}
+		return m.clampCursor(), cmd
+
+	case tickMsg:
+		logger.Debug("tick", "args", m.args, "locked", m.locked())
+
+		//	Departures shown once don't refresh
+		if m.once > 0 {
+			return m, nil
+		}
+
+		//	Fire any commute alarms that are due, even while the terminal is
+		//	unfocused
+		var notify tea.Cmd
+		if len(m.notify) > 0 {
+			notify = m.checkNotifications(time.Now())
+		}
+
+		//	Keep ticking while the terminal is unfocused, but don't fetch
+		//	departures for display
+		m.nextRefresh = time.Now().Add(m.refreshInterval())
+		if m.blurred {
+			return m, tea.Batch(notify, tick(m.refreshInterval()))
+		}
+
+		// If locked to a station (args provided), refresh that station’s departures
+		var refresh tea.Cmd
+		if m.locked() {
+			refresh = m.fetchLocked(station{Abbr: m.args[0]})
+		}
+
+		// schedule the next tick
+		return m, tea.Batch(refresh, m.fetchBoard(), notify, tick(m.refreshInterval()))
+
+	//	Shows the locked station's departures (from fetchLocked)
+	case lockedMsg:
+		//	Ignore a response for a station that is no longer locked
+		if !m.locked() || !strings.EqualFold(msg.station.Abbr, m.args[0]) {
+			return m, nil
+		}
+		var lookup tea.Cmd
+		if msg.err == nil {
+			m, lookup = m.noteServiceEnd(msg.station.Abbr, msg.deps)
+		}
+		m = m.showLocked(msg)
+		//	With --once, quit a while after the departures show
+		if m.once > 0 {
+			return m, tea.Batch(lookup, tea.Tick(m.once, func(time.Time) tea.Msg { return tea.Quit() }))
+		}
+		return m, lookup
+
+	//	Remembers which trains have been notified (from checkNotifications)
+	case notifiedMsg:
+		m.notified = msg.notified
+		return m, nil
+
+	//	Pause fetching while the terminal is in the background, and catch up
+	//	as soon as it comes back
+	case tea.BlurMsg:
+		m.blurred = true
+		return m, nil
+	case tea.FocusMsg:
+		m.blurred = false
+		if m.locked() {
+			return m, tea.Batch(m.fetchLocked(station{Abbr: m.args[0]}), m.fetchBoard())
+		}
+		return m, m.fetchBoard()
+
+	//	Nothing changes, the View just redraws the countdown
+	case countdownMsg:
+		return m, countdown()
+
+	//	Handles message containing board departures (from fetchBoard)
+	case boardMsg:
+		//	Keep a station's last good departures through a failed refresh;
+		//	its error marks them stale
+		deps := maps.Clone(msg.deps)
+		updated := make(map[string]time.Time)
+		for abbr := range msg.deps {
+			updated[abbr] = time.Now()
+		}
+		for abbr := range msg.errs {
+			if old, ok := m.boardDeps[abbr]; ok {
+				deps[abbr], updated[abbr] = old, m.boardUpdated[abbr]
+			}
+		}
+		m.boardDeps, m.boardErrs, m.boardUpdated = deps, msg.errs, updated
+		return m, nil
+
+	//	Handles errors
+	case error:
+		logger.Error("loading stations", "err", msg)
+		m.err = msg
+
+		//	The problem is local: say so and keep trying
+		if isNetworkError(msg) {
+			m.message = "No internet connection — retrying..."
+			apiKey := m.api_key
+			return m, tea.Tick(networkRetryDelay, func(time.Time) tea.Msg {
+				return fetchStations(apiKey)()
+			})
+		}
+		m.message = "Error loading stations: " + msg.Error()
+		return m, nil
+	}
+	return m, nil
+}
+
+// Whether err means BART couldn't be reached at all (offline, DNS failure,
+// connection refused or timed out), rather than an API or key problem
+func isNetworkError(err error) bool {
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	if errors.As(err, &opErr) || errors.As(err, &dnsErr) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Reports whether nothing can be shown yet because the station list (and
+// any station given as an argument) is still loading
+func (m model) startingUp() bool {
+	return m.stations == nil && m.info == "" && m.err == nil && len(m.board) == 0
+}
+
+// First screen, shown until the stations load
+func (m model) startupView() string {
+	text := "Fetching BART stations — press q to cancel"
+	if len(m.spinner.Spinner.Frames) > 0 {
+		text = m.spinner.View() + " " + text
+	}
+	return "\n" + m.title("BART Schedule") + "\n\n" + text + "\n" + m.statusLine()
+}
+
+// "Loading departures for Powell St..." without doubling the name's own
+// trailing period
+func loadingMessage(name string) string {
+	return "Loading departures for " + strings.TrimSuffix(name, ".") + "..."
+}
+
+// Placeholder shown while a newly picked station's departures are fetched;
+// refreshing the station already shown keeps its departures up meanwhile
+func (m model) loadingText() string {
+	text := loadingMessage(m.loading.Name)
+	if len(m.spinner.Spinner.Frames) > 0 {
+		text = m.spinner.View() + " " + text
+	}
+	return m.title(m.loading.Name) + "\n\n" + text
+}
+
+// How a binding's key is written in hints: 'q' for a character, else a
+// name like Enter
+func keyHint(b key.Binding) string {
+	k := b.Help().Key
+	if len([]rune(k)) == 1 {
+		return "'" + k + "'"
+	}
+	return strings.ToUpper(k[:1]) + k[1:]
+}
+
+// Key hints for the current mode, with a subtle countdown to the next
+// refresh once one is due
+func (m model) footer() string {
+	km := m.keyMap()
+	keys := fmt.Sprintf("Press %s to quit. Press %s to refresh", keyHint(km.Quit), keyHint(km.Refresh))
+
+	quit := fmt.Sprintf("Press %s to quit.", keyHint(km.Quit))
+
+	var footer string
+	switch {
+	case m.once > 0:
+		return "\nPress any key to exit"
+	case m.err != nil:
+		return "\n" + quit
+	case m.showAdvisories:
+		return "\nPress 'a' to go back. " + quit
+	case m.showRoutes:
+		return fmt.Sprintf("\nPress %s to see a line's stations. Press 'l' to go back. %s", keyHint(km.Enter), quit)
+	case len(m.board) > 0:
+		footer = "\nPress Esc for the station list. " + quit
+	case m.filtering:
+		//	The search box takes every other key as text, so Enter is fixed
+		return "\nPress Esc to clear, Tab to complete, Enter to select"
+	case m.filter != "":
+		footer = fmt.Sprintf("\nPress %s to select. Press Esc to clear the search. %s", keyHint(km.Enter), keys)
+	case m.locked():
+		footer = "\nPress Esc for the station list. " + keys
+	default:
+		footer = "\n" + keys
+	}
+	if m.nextRefresh.IsZero() || !m.autoRefreshing() {
+		return footer
+	}
+	left := max(time.Until(m.nextRefresh).Round(time.Second), 0)
+	return footer + m.theme.Faint.Render(fmt.Sprintf(" · next refresh in %ds", int(left.Seconds())))
+}
+
+// Renders the UI
+func (m model) View() string {
+	if m.err != nil {
+		return m.message + "\n" + m.footer()
+	}
+
+	//	Advisories replace everything else while toggled on
+	if m.showAdvisories {
+		return m.advisoriesView()
+	}
+
+	//	Route list replaces the normal view while toggled on
+	if m.showRoutes {
+		return m.routesView()
+	}
+
+	//	Multi-station board replaces the station list
+	if len(m.board) > 0 {
+		return m.boardView()
+	}
+
+	if m.startingUp() {
+		return m.startupView()
+	}
+
+	// If there is a station list, render side-by-side view
+	if len(m.stations) > 0 {
+
+		//	Left side: station list
+		stationList := m.stationListView()
+
+		//	Right side: departure info (or hint text)
+		departures := "\n" + m.theme.Header.Render("Departures:") + "\n\n"
+		if m.loading.Abbr != "" && m.loading.Abbr != m.viewing.Abbr {
+			departures += m.loadingText()
+		} else if m.info != "" {
+			departures += m.info + m.arrivalsText()
+		} else {
+			departures += "Press Enter to see departures"
+		}
+
+		//	Too narrow for two columns: list stations, then departures
+		listWidth, gap := m.columnWidths()
+		if m.width > 0 && m.width < listWidth+gap+minDeparturesWidth {
+			var out strings.Builder
+			out.Grow(len(stationList) + len(departures))
+			for _, line := range strings.Split(stationList+departures, "\n") {
+				for _, wrapped := range wrapText(line, m.width) {
+					out.WriteString(wrapped)
+					out.WriteByte('\n')
+				}
+			}
+			out.WriteString(m.statusLine())
+			out.WriteString(m.footer())
+			return out.String()
+		}
+
+		// Combine left and right columns line by line
+		leftLines := strings.Split(stationList, "\n")
+		var rightLines []string
+		for _, line := range strings.Split(departures, "\n") {
+			//	Wrap long lines to the space left of the terminal, once known
+			if m.width > 0 {
+				rightLines = append(rightLines, wrapText(line, m.width-listWidth-gap)...)
+			} else {
+				rightLines = append(rightLines, line)
+			}
+		}
+
+		maxLines := len(leftLines)
+		if len(rightLines) > maxLines {
+			maxLines = len(rightLines)
+		}
+
+		//	Each line is at most the padded left column plus the right one
+		var out strings.Builder
+		out.Grow(maxLines*(listWidth+gap) + len(stationList) + len(departures))
+		for i := 0; i < maxLines; i++ {
+			var left, right string
+			if i < len(leftLines) {
+				left = leftLines[i]
+			}
+			if i < len(rightLines) {
+				right = rightLines[i]
+			}
+			//	Pad left side to align columns, ignoring any style escape codes
+			if pad := listWidth - lipgloss.Width(left); pad > 0 {
+				left += strings.Repeat(" ", pad)
+			}
+			out.WriteString(left)
+			out.WriteString(strings.Repeat(" ", gap))
+			out.WriteString(right)
+			out.WriteByte('\n')
+		}
+
+		out.WriteString(m.statusLine())
+		out.WriteString(m.footer())
+		return out.String()
+	}
+
+	//	If station list is cleared, show just message + departures
+	return fmt.Sprintf("%s\n\n%s%s\n%s%s", m.theme.Header.Render(m.message), m.info, m.arrivalsText(), m.statusLine(), m.footer())
+}
+
+// Widths of the station list column and the gap after it. With fitList the
+// column is just wide enough for the longest station, marks and all.
+func (m model) columnWidths() (listWidth, gap int) {
+	listWidth, gap = m.listWidth, m.gap
+	if listWidth <= 0 {
+		listWidth = defaultListWidth
+	}
+	if gap <= 0 {
+		gap = defaultGap
+	}
+	if m.fitList {
+		listWidth = lipgloss.Width("BART Stations:")
+		for _, s := range m.stations {
+			listWidth = max(listWidth, lipgloss.Width(fmt.Sprintf("    %s, (%s) ★9 ✓", s.Name, s.Abbr)))
+		}
+	}
+	return listWidth, gap
+}
+
+// Everything the station list is rendered from besides favorites and the
+// board selection, which are compared separately. The theme is left out
+// since it's fixed once the program starts.
+type stationListKey struct {
+	stations    *station
+	count       int
+	cursor      int
+	filter      string
+	filtering   bool
+	plainCursor bool
+	stationSort int
+}
+
+// The station list as last rendered, reused until its inputs change
+type stationListCache struct {
+	key       stationListKey
+	favorites []string
+	selected  map[string]bool
+	rendered  string
+}
+
+// The left column, rendered again only when the stations, cursor, search,
+// order, favorites or selection have changed since the last frame
+func (m model) stationListView() string {
+	if m.listCache == nil {
+		return m.renderStationList()
+	}
+
+	key := stationListKey{
+		count:       len(m.stations),
+		cursor:      m.cursor,
+		filter:      m.filter,
+		filtering:   m.filtering,
+		plainCursor: m.plainCursor,
+		stationSort: m.stationSort,
+	}
+	if len(m.stations) > 0 {
+		key.stations = &m.stations[0]
+	}
+
+	c := m.listCache
+	if c.rendered != "" && c.key == key && slices.Equal(c.favorites, m.favorites) && maps.Equal(c.selected, m.selected) {
+		return c.rendered
+	}
+	//	The selection is changed in place, so keep a copy to compare against
+	*c = stationListCache{key: key, favorites: m.favorites, selected: maps.Clone(m.selected), rendered: m.renderStationList()}
+	return c.rendered
+}
 
-				//	Format the departure info
-				var infoStr string
-				infoStr = selected.Name + "\n\n"
-				//	sort the departures in alphabetical order
-				var keys []string
-				for dest := range deps {
-					keys = append(keys, dest)
-				}
-				sort.Strings(keys)
-
-				for _, dest := range keys {
-					depList := deps[dest]
-					infoStr += fmt.Sprintf("%s:\n", dest)
-					for _, dep := range depList {
-						if dep.Minutes == "Leaving" {
-							infoStr += fmt.Sprintf(" %s | Platform %s\n", dep.Minutes, dep.Platform)
-						} else if min, err := strconv.Atoi(dep.Minutes); err == nil && min < 10 {
-							infoStr += fmt.Sprintf("   %s min | Platform %s\n", dep.Minutes, dep.Platform)
-						} else {
-							infoStr += fmt.Sprintf("  %s min | Platform %s\n", dep.Minutes, dep.Platform)
-						}
-					}
-					infoStr += "\n"
-				}
+// Renders the station list with its search line and cursor
+func (m model) renderStationList() string {
+	visible := m.visibleStations()
+
+	var b strings.Builder
+	b.Grow(64 * (len(visible) + 4))
+	b.WriteString("\n" + m.theme.Header.Render("BART Stations:") + "\n\n")
 
-				m.info = infoStr
+	if m.filtering || m.filter != "" {
+		b.WriteString("Search: " + m.filter)
+		if m.filtering {
+			//	Ghost the rest of the best matching abbreviation, accepted with Tab
+			if suffix := m.completion(); suffix != "" {
+				b.WriteString(m.theme.Faint.Render(suffix))
 			}
-			return m, nil
+			b.WriteString("_")
 		}
+		fmt.Fprintf(&b, "\nShowing %d of %d stations\n\n", len(visible), len(m.stations))
+	}
 
-	//	Handles message containing stations (from fetchStations)
-	case []station:
-		m.stations = msg
-		m.message = "\nLive Tracking\n============="
+	if len(visible) == 0 {
+		b.WriteString("  No stations match\n")
+	}
+	for i, s := range visible {
+		//	City headings when grouped by city
+		if stationSorts[m.stationSort] == "city" && (i == 0 || visible[i-1].City != s.City) {
+			b.WriteString(m.theme.Header.Render(s.City) + "\n")
+		}
+		line := fmt.Sprintf("%s, (%s)", s.Name, s.Abbr)
+		if m.isFavorite(s.Abbr) {
+			line += " ★"
+			//	The first nine favorites are numbered for their jump keys
+			if n := slices.Index(m.favorites, strings.ToUpper(s.Abbr)) + 1; n <= 9 {
+				line += strconv.Itoa(n)
+			}
+		}
+		if m.selected[strings.ToUpper(s.Abbr)] {
+			line += " ✓"
+		}
+		if i == m.cursor && m.plainCursor {
+			b.WriteString("    " + line + "\n")
+		} else if i == m.cursor {
+			b.WriteString(m.theme.Cursor.Render(">") + " " + m.theme.Selected.Render(line) + "\n")
+		} else {
+			b.WriteString("  " + line + "\n")
+		}
+	}
+	return b.String()
+}
 
-		//	If the user provided an argument, skip the list and show departures directly
-		if len(m.args) > 0 {
-			stationAbbr := strings.ToUpper(m.args[0])
-			for _, st := range m.stations {
-				if strings.EqualFold(st.Abbr, stationAbbr) {
-					//	Save the station name
-					m.selectedName = st.Name
-					//	fetch departures immediately
-					deps, err := getDepartures(m.api_key, st.Abbr)
-					if err != nil {
-						m.info = fmt.Sprintf("Error fetching departures for %s: %v", st.Abbr, err)
-					} else {
-						var infoStr string
-						infoStr = st.Name + " Departures\n\n"
-
-						//	sort the departures in alphabetical order
-						var keys []string
-						for dest := range deps {
-							keys = append(keys, dest)
-						}
-						sort.Strings(keys)
-
-						for _, dest := range keys {
-							depList := deps[dest]
-							infoStr += fmt.Sprintf("%s:\n", dest)
-							for _, dep := range depList {
-								if dep.Minutes == "Leaving" {
-									infoStr += fmt.Sprintf(" %s | Platform %s\n", dep.Minutes, dep.Platform)
-								} else if min, err := strconv.Atoi(dep.Minutes); err == nil && min < 10 {
-									infoStr += fmt.Sprintf("   %s min | Platform %s\n", dep.Minutes, dep.Platform)
-								} else {
-									infoStr += fmt.Sprintf("  %s min | Platform %s\n", dep.Minutes, dep.Platform)
-								}
-							}
-							infoStr += "\n"
-						}
-
-						m.info = infoStr
-					}
+// Word-wraps s to lines at most width columns wide, keeping its indentation.
+// Words longer than width are broken across lines.
+func wrapText(s string, width int) []string {
+	if width <= 0 || lipgloss.Width(s) <= width {
+		return []string{s}
+	}
 
-					// Clear stations so the station list doesn't render
-					m.stations = nil
+	indent := s[:len(s)-len(strings.TrimLeft(s, " "))]
+	if len(indent) >= width {
+		indent = ""
+	}
+
+	var lines []string
+	line := indent
+	for _, word := range strings.Fields(s) {
+		//	Break words that can never fit on a line
+		for lipgloss.Width(indent+word) > width {
+			if line != indent {
+				lines = append(lines, line)
+			}
+			//	Take the runes that fit in the columns left after the indent,
+			//	by display width, and at least one so a wide rune can't stall
+			runes := []rune(word)
+			cut, cols := 0, 0
+			for _, r := range runes {
+				w := lipgloss.Width(string(r))
+				if cut > 0 && cols+w > width-len(indent) {
 					break
 				}
+				cut, cols = cut+1, cols+w
 			}
+			lines = append(lines, indent+string(runes[:cut]))
+			word = string(runes[cut:])
+			line = indent
 		}
-		return m, nil
 
-	case tickMsg:
-		// If locked to a station (args provided), refresh that station’s departures
-		if len(m.args) > 0 && m.stations == nil {
-			stationAbbr := strings.ToUpper(m.args[0])
-			deps, err := getDepartures(m.api_key, stationAbbr)
-			if err != nil {
-				m.info = fmt.Sprintf("Error refreshing departures for %s: %v", stationAbbr, err)
-			} else {
-				var infoStr string
-				displayName := stationAbbr
-				if m.selectedName != "" {
-					displayName = m.selectedName
-				}
-				infoStr = displayName + " Departures\n\n"
+		switch {
+		case line == indent:
+			line += word
+		case lipgloss.Width(line)+1+lipgloss.Width(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = indent + word
+		}
+	}
+	if line != indent {
+		lines = append(lines, line)
+	}
+	return lines
+}
 
-				//	sort the departures in alphabetical order
-				var keys []string
-				for dest := range deps {
-					keys = append(keys, dest)
+// The soonest shown train across all board stations, e.g.
+// "Soonest: Powell St. (POWL) → Dublin in 4 min, Platform 2", or "" if none
+func (m model) soonestOnBoard(names map[string]string) string {
+	var bestAbbr, bestDest string
+	var best departureInfo
+	bestKey := unknownMinutes
+	for _, abbr := range m.board {
+		deps := m.boardDeps[abbr]
+		var dests []string
+		for dest := range deps {
+			dests = append(dests, dest)
+		}
+		sort.Strings(dests)
+		for _, dest := range dests {
+			for _, dep := range deps[dest] {
+				if m.hides(dep) {
+					continue
 				}
-				sort.Strings(keys)
-
-				for _, dest := range keys {
-					depList := deps[dest]
-					infoStr += fmt.Sprintf("%s:\n", dest)
-					for _, dep := range depList {
-						if dep.Minutes == "Leaving" {
-							infoStr += fmt.Sprintf(" %s | Platform %s\n", dep.Minutes, dep.Platform)
-						} else if min, err := strconv.Atoi(dep.Minutes); err == nil && min < 10 {
-							infoStr += fmt.Sprintf("   %s min | Platform %s\n", dep.Minutes, dep.Platform)
-						} else {
-							infoStr += fmt.Sprintf("  %s min | Platform %s\n", dep.Minutes, dep.Platform)
-						}
-					}
-					infoStr += "\n"
+				if key := minutesSortKey(dep.Minutes); key < bestKey {
+					bestAbbr, bestDest, best, bestKey = abbr, dest, dep, key
 				}
+			}
+		}
+	}
+	if bestAbbr == "" {
+		return ""
+	}
+
+	where := bestAbbr
+	if name, ok := names[bestAbbr]; ok {
+		where = name + " (" + bestAbbr + ")"
+	}
+	line := fmt.Sprintf("Soonest: %s → %s %s", where, bestDest, m.humanizeMinutes(best.Minutes))
+	if best.Platform != "" {
+		line += ", Platform " + best.Platform
+	}
+	return line
+}
+
+// Renders the board stations as panels, as many per row as fit the terminal
+func (m model) boardView() string {
+	const panelWidth = 40
+
+	names := make(map[string]string)
+	for _, st := range m.stations {
+		names[strings.ToUpper(st.Abbr)] = st.Name
+	}
 
-				m.info = infoStr
+	var panels []string
+	for _, abbr := range m.board {
+		title := abbr
+		if name, ok := names[abbr]; ok {
+			title = name + " (" + abbr + ")"
+		}
+
+		var body string
+		switch {
+		case m.boardErrs[abbr] != nil && m.boardDeps[abbr] == nil:
+			body = fmt.Sprintf("Error: %v", m.boardErrs[abbr])
+		case m.boardDeps[abbr] == nil:
+			body = "Loading..."
+		default:
+			body = formatDepartures(m.boardDeps[abbr], m.optionsFor(abbr))
+			if m.boardErrs[abbr] != nil {
+				body = staleNote(m.boardUpdated[abbr]) + "\n" + body
 			}
 		}
 
-		// schedule the next tick
-		return m, tea.Tick(5*time.Second, func(time.Time) tea.Msg {
-			return tickMsg{}
-		})
+		panel := m.theme.Header.Render(title) + "\n\n" + body
+		panels = append(panels, lipgloss.NewStyle().Width(panelWidth).PaddingRight(2).Render(panel))
+	}
 
-	//	Handles errors
-	case error:
-		m.err = msg
-		m.message = "Error loading stations: " + msg.Error()
-		return m, nil
+	perRow := len(panels)
+	if m.width > 0 {
+		perRow = max(1, m.width/(panelWidth+2))
 	}
-	return m, nil
+
+	var rows []string
+	for i := 0; i < len(panels); i += perRow {
+		rows = append(rows, lipgloss.JoinHorizontal(lipgloss.Top, panels[i:min(i+perRow, len(panels))]...))
+	}
+
+	//	Highlight the soonest train overall above the panels
+	var top string
+	if soonest := m.soonestOnBoard(names); soonest != "" {
+		top = m.theme.Selected.Render(soonest) + "\n\n"
+	}
+
+	return "\n" + top + strings.Join(rows, "\n\n") + "\n" + m.statusLine() + m.footer()
 }
 
-// Renders the UI
-func (m model) View() string {
-	if m.err != nil {
-		return fmt.Sprintf("%s\n\nPress 'q' to quit.", m.message)
+// Warns that departures are from an earlier refresh, and how old they are
+func staleNote(lastUpdate time.Time) string {
+	stale := "⚠ stale"
+	if !lastUpdate.IsZero() {
+		stale += " — last update " + time.Since(lastUpdate).Round(time.Second).String() + " ago"
 	}
+	return stale
+}
 
-	// If there is a station list, render side-by-side view
-	if len(m.stations) > 0 {
+// Status line shown above the footer, if any
+func (m model) statusLine() string {
+	status := m.status
+	if m.stale {
+		status = strings.TrimSpace(staleNote(m.lastUpdate) + "  " + status)
+	}
+	if status == "" {
+		return ""
+	}
+	return "\n" + status + "\n"
+}
 
-		//	Left side: station list
-		stationList := "\nBART Stations:\n\n"
+// Scheduled arrivals at the --arrive destination, if any
+func (m model) arrivalsText() string {
+	if len(m.arrivals) == 0 {
+		return ""
+	}
+	out := fmt.Sprintf("Arrivals at %s:\n", m.arriveAt)
+	for _, t := range m.arrivals {
+		out += fmt.Sprintf("  %s from %s → arrives %s\n", t.OrigTime, t.Origin, t.DestTime)
+	}
+	return out + "\n"
+}
 
-		for i, s := range m.stations {
-			cursor := " "
-			if i == m.cursor {
-				cursor = ">"
+// Advisory text wrapped to the terminal, one entry per paragraph
+func (m model) advisoryLines() []string {
+	var lines []string
+	for i, adv := range m.advisories {
+		if i > 0 {
+			lines = append(lines, "")
+		}
+		header := strings.TrimSpace(adv.Type + " " + adv.Station)
+		if adv.Posted != "" {
+			header += " (posted " + adv.Posted + ")"
+		}
+		if header != "" {
+			lines = append(lines, m.theme.Selected.Render(header))
+		}
+		if text := strings.TrimSpace(adv.Description.Text); text != "" {
+			for _, line := range strings.Split(text, "\n") {
+				lines = append(lines, wrapText(line, m.width)...)
 			}
-			stationList += fmt.Sprintf("%s %s, (%s)\n", cursor, s.Name, s.Abbr)
 		}
+	}
+	return lines
+}
 
-		//	Right side: departure info (or hint text)
-		departures := "\nDepartures:\n\n"
-		if m.info != "" {
-			departures += m.info
+// Renders every current service advisory, scrolled to fit the terminal
+func (m model) advisoriesView() string {
+	out := "\n" + m.theme.Header.Render("Service Advisories:") + "\n\n"
+	if m.advisories == nil {
+		return out + "Loading advisories...\n" + m.footer()
+	}
+
+	lines := m.advisoryLines()
+	if len(lines) == 0 {
+		lines = []string{"No advisories"}
+	}
+
+	//	Leave room for the title and footer once the height is known
+	start := min(m.advisoryScroll, len(lines)-1)
+	end := len(lines)
+	if m.height > 0 {
+		end = min(end, start+max(m.height-6, 1))
+	}
+	out += strings.Join(lines[start:end], "\n") + "\n"
+	if start > 0 || end < len(lines) {
+		out += fmt.Sprintf("\n(lines %d-%d of %d, up/down to scroll)\n", start+1, end, len(lines))
+	}
+
+	return out + m.footer()
+}
+
+// Crude diagram of where the selected line's trains probably are, guessed
+// from how soon each station expects its next train toward the end of the
+// line: ● a train is at (or a minute from) the station, ▼ one is between
+// it and the station before
+func (m model) trainMapView(names map[string]string) string {
+	r := m.routes[m.routeCursor]
+	origin, final := m.routeStops[0], m.routeStops[len(m.routeStops)-1]
+
+	//	Trains of this line's color, not heading back to where it starts
+	heading := func(dest string, dep departureInfo) bool {
+		if dep.Color == "" {
+			return strings.EqualFold(dest, names[final])
+		}
+		return strings.EqualFold(dep.Color, r.Color) && !strings.EqualFold(dest, names[origin])
+	}
+
+	out := "\nTrain positions (experimental, estimated from departure times):\n\n"
+	for i, abbr := range m.routeStops {
+		soonest := unknownMinutes
+		for dest, deps := range m.trainMap[abbr] {
+			for _, dep := range deps {
+				if heading(dest, dep) {
+					soonest = min(soonest, minutesSortKey(dep.Minutes))
+				}
+			}
+		}
+
+		if i > 0 {
+			if soonest > 1 && soonest <= 3 {
+				out += "  ▼\n"
+			} else {
+				out += "  │\n"
+			}
+		}
+		marker := "○"
+		if soonest <= 1 {
+			marker = "●"
+		}
+		if name, ok := names[abbr]; ok {
+			out += fmt.Sprintf("  %s %s (%s)\n", marker, name, abbr)
 		} else {
-			departures += "Press Enter to see departures"
+			out += fmt.Sprintf("  %s %s\n", marker, abbr)
 		}
+	}
+	return out
+}
 
-		// Combine left and right columns line by line
-		leftLines := strings.Split(stationList, "\n")
-		rightLines := strings.Split(departures, "\n")
+// Renders the list of BART lines with their endpoints and colors
+func (m model) routesView() string {
+	if m.routes == nil {
+		return "\nLoading BART lines...\n" + m.footer()
+	}
 
-		maxLines := len(leftLines)
-		if len(rightLines) > maxLines {
-			maxLines = len(rightLines)
+	out := "\nBART Lines:\n\n"
+	for i, r := range m.routes {
+		cursor := " "
+		if i == m.routeCursor && m.plainCursor {
+			cursor = "   "
+		} else if i == m.routeCursor {
+			cursor = ">"
 		}
+		from, to := r.endpoints()
+		out += fmt.Sprintf("%s %s %-7s %-8s %s → %s  (%s)\n", cursor, m.lineMarker(r.Color, r.HexColor), r.Color, r.HexColor, from, to, r.Name)
+	}
 
-		var out string
-		for i := 0; i < maxLines; i++ {
-			var left, right string
-			if i < len(leftLines) {
-				left = leftLines[i]
-			}
-			if i < len(rightLines) {
-				right = rightLines[i]
+	//	Ordered stops of the selected route, using full names when known
+	if len(m.routeStops) > 0 {
+		names := make(map[string]string)
+		for _, st := range m.stations {
+			names[st.Abbr] = st.Name
+		}
+
+		if m.experimentalMap && m.trainMap != nil {
+			return out + m.trainMapView(names) + m.footer()
+		}
+
+		out += "\nStations served:\n\n"
+		for i, abbr := range m.routeStops {
+			if name, ok := names[abbr]; ok {
+				out += fmt.Sprintf("%2d. %s (%s)\n", i+1, name, abbr)
+			} else {
+				out += fmt.Sprintf("%2d. %s\n", i+1, abbr)
 			}
-			out += fmt.Sprintf("%-70s  %s\n", left, right) //	Pad left side to align columns
 		}
+	}
+
+	return out + m.footer()
+}
 
-		return out + "\nPress 'q' to quit. Press 'r' to refresh"
+// Reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
 	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
 
-	//	If station list is cleared, show just message + departures
-	return fmt.Sprintf("%s\n\n%s\n\nPress 'q' to quit. Press 'r' to refresh", m.message, m.info)
+// Prints departures for the station in args, or the station list without
+// args, as plain text for non-interactive output
+func printOnce(w io.Writer, apiKey string, args []string, opts renderOptions) error {
+	if len(args) > 0 {
+		abbr := strings.ToUpper(args[0])
+		deps, err := getDepartures(apiKey, abbr, "")
+		if err != nil {
+			return err
+		}
+		if now := clock(); len(deps) == 0 && lateNight(now) {
+			opts.firstTrain, _ = firstScheduledTrain(apiKey, abbr, now)
+		}
+		fmt.Fprintf(w, "%s Departures\n\n%s", abbr, formatDepartures(deps, opts))
+		return nil
+	}
+
+	msg := fetchStations(apiKey)()
+	if err, ok := msg.(error); ok {
+		return err
+	}
+	for _, st := range msg.([]station) {
+		fmt.Fprintf(w, "%s, (%s)\n", st.Name, st.Abbr)
+	}
+	return nil
 }
 
 func main() {
-	api_key := os.Getenv("BART_API_KEY")
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("\nError reading config file: %v\n", err)
+		os.Exit(exitConfig)
+	}
+
+	apiKeyFlag := flag.String("api-key", "", "BART API key (overrides BART_API_KEY and the config file)")
+	refresh := flag.Duration("refresh", 5*time.Second, "how often to refresh departures")
+	requestRate := flag.Float64("rate-limit", 5, "most BART API requests per second, to avoid being throttled (0 for no limit)")
+	var notify notifyFlag
+	flag.Var(&notify, "notify", "notify when a train is N minutes away, as STATION:DESTINATION:MINUTES (repeatable)")
+	arriveAt := flag.String("arrive", "", "also show scheduled arrivals at this destination station")
+	noRestore := flag.Bool("no-restore", false, "don't remember or restore the last viewed station and view settings")
+	themeName := flag.String("theme", "", "color scheme: dark, light or bart (default dark or light to suit the terminal background)")
+	noColor := flag.Bool("no-color", false, "disable all colors and styling")
+	plainCursor := flag.Bool("plain-cursor", false, "indent the selected row instead of marking it with \">\"")
+	plain := flag.Bool("plain", false, "plain output for screenshots and recordings: same as --no-color --plain-cursor")
+	symbols := flag.Bool("symbols", false, "tag line colors with letters like [Y] (always on with --no-color)")
+	minMinutes := flag.Int("min-minutes", 0, "hide trains leaving in fewer than N minutes")
+	showLeaving := flag.Bool("show-leaving", false, "keep \"Leaving\" trains visible with --min-minutes")
+	terse := flag.Bool("terse", false, "show minutes as \"5 min\" rather than \"in 5 min\"")
+	columns := flag.String("columns", "", "fields shown for each train, in order: "+strings.Join(departureColumns, ",")+" (default minutes,platform)")
+	histogram := flag.Bool("histogram", false, "show a bar of trains per 10 minutes for each destination (toggle with h)")
+	walk := flag.Int("walk", 0, "minutes it takes you to walk to the station (see --near), to highlight the trains you can catch")
+	allDirections := flag.Bool("all-directions", false, "always show Northbound and Southbound, even when one has no trains")
+	maxPerDest := flag.Int("max-per-dest", 0, "show at most N trains per destination, noting how many more there are (0 for all)")
+	listWidth := flag.Int("list-width", defaultListWidth, "width of the station list column, or 0 to fit the longest station name")
+	gap := flag.Int("gap", defaultGap, "spaces between the station list and departures")
+	nextOnly := flag.Bool("next-only", false, "show only the next train for each destination (toggle with n)")
+	favorites := flag.Bool("favorites", false, "show a board of your starred stations (star with *)")
+	preset := flag.String("preset", "", "show a board of a named station group, e.g. downtown")
+	flag.BoolVar(&retryEmpty, "retry-empty", false, "retry once when a station returns no departures, to ride out transient blips")
+	flag.IntVar(&estimateCount, "count", 0, fmt.Sprintf("estimates to request per destination, %d-%d (default BART's usual)", minEstimateCount, maxEstimateCount))
+	confirmQuit := flag.Bool("confirm-quit", false, "require pressing q twice to quit")
+	experimentalMap := flag.Bool("experimental-map", false, "experimental: show estimated train positions when viewing a line's stations")
+	wrapCursor := flag.Bool("wrap", false, "wrap the station cursor around at the top and bottom of the list")
+	autoSelect := flag.Bool("auto-select", false, "show departures as soon as the search narrows to one station")
+	apiURL := flag.String("api-url", "", "base URL of the BART API (default $BART_API_URL or "+baseURL+")")
+	near := flag.String("near", "", "your location as LAT,LON, to show distance and walking time to stations")
+	demo := flag.Bool("demo", false, "run offline with bundled sample data")
+	compact := flag.Bool("compact", false, "print the soonest departure per destination for the given station and exit")
+	atTime := flag.String("at", "", "print scheduled departures in the hour after this time (e.g. 18:00) for the given station and exit")
+	exitCode := flag.Bool("exit-code", false, "print nothing and exit 0 if a train leaves the given station within --within minutes, 1 if not (see the exit codes below)")
+	within := flag.Int("within", 10, "minutes for --exit-code")
+	to := flag.String("to", "", "only count trains whose destination contains this text with --exit-code")
+	withScheduled := flag.Bool("with-scheduled", false, "after the live estimates, show scheduled trains for the next hour")
+	once := flag.Bool("once", false, "show the given station's departures once, then exit after --once-delay or any key")
+	onceDelay := flag.Duration("once-delay", 10*time.Second, "how long --once shows departures before exiting")
+	jsonOut := flag.Bool("json", false, "print departures for the given stations as JSON, one object per station per line, and exit")
+	watch := flag.Bool("watch", false, "with --json, keep printing departures every --refresh until interrupted")
+	csvOut := flag.Bool("csv", false, "print departures for the given stations as CSV and exit")
+	noAltScreen := flag.Bool("no-alt-screen", false, "run inline instead of in the terminal's alternate screen")
+	logPath := flag.String("log", "", "write debug logs to this file")
+	showVersion := flag.Bool("version", false, "print version information and exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [station ...]\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), exitCodesHelp)
+	}
+	//	Bad flags exit with exitUsage rather than the flag package's 2, which
+	//	means an invalid API key here
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		os.Exit(exitUsage)
+	}
+
+	if *showVersion {
+		fmt.Printf("bart-schedule %s (commit %s, built %s)\n", version, commit, date)
+		return
+	}
+
+	if estimateCount != 0 && (estimateCount < minEstimateCount || estimateCount > maxEstimateCount) {
+		fmt.Printf("\n--count must be between %d and %d\n", minEstimateCount, maxEstimateCount)
+		os.Exit(exitUsage)
+	}
+	if *listWidth < 0 || *gap < 1 {
+		fmt.Println("\n--list-width must be 0 or more and --gap at least 1")
+		os.Exit(exitUsage)
+	}
+
+	var logFile *os.File
+	if *demo {
+		//	Offline demo with canned responses; set before --log wraps
+		//	requests so they're logged too
+		httpGet = demoGet
+	}
+	if *logPath != "" {
+		f, err := os.OpenFile(*logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Printf("\nError opening log file: %v\n", err)
+			os.Exit(exitConfig)
+		}
+		defer f.Close()
+		logFile = f
+		logger = slog.New(slog.NewTextHandler(f, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		httpGet = logRequests(httpGet)
+	}
+
+	//	Flags that were given explicitly take precedence over the config file
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	if *apiURL != "" {
+		baseURL = strings.TrimSuffix(*apiURL, "/")
+	} else if env := os.Getenv("BART_API_URL"); env != "" {
+		baseURL = strings.TrimSuffix(env, "/")
+	}
+
+	//	API key precedence: flag > env var > config file
+	api_key := *apiKeyFlag
+	if *demo {
+		//	Offline demo: no key or network needed
+		api_key = "demo"
+	}
+	if api_key == "" {
+		api_key = os.Getenv("BART_API_KEY")
+	}
+	if api_key == "" {
+		api_key = cfg.APIKey
+	}
 	if api_key == "" {
-		fmt.Println("\nPlease set BART_API_KEY environment variable: \n\nexport BART_API_KEY=(your api key)\n ")
-		os.Exit(1)
+		fmt.Println("\nPlease set BART_API_KEY environment variable: \n\nexport BART_API_KEY=(your api key)\n\nor add \"api_key\" to ~/.config/bart-schedule/config.json\n ")
+		os.Exit(exitConfig)
+	}
+
+	if !setFlags["theme"] && cfg.Theme != "" {
+		*themeName = cfg.Theme
+	}
+	if !setFlags["refresh"] && cfg.Refresh > 0 {
+		*refresh = time.Duration(cfg.Refresh) * time.Second
+	}
+	if !setFlags["rate-limit"] && cfg.RateLimit > 0 {
+		*requestRate = cfg.RateLimit
+	}
+	if *requestRate > 0 {
+		httpGet = throttle(httpGet, rate.NewLimiter(rate.Limit(*requestRate), rateBurst))
+	}
+
+	args := withDefaultStation(flag.Args(), cfg)
+
+	//	Fail fast on a bad key or an unreachable API
+	if err := validateAPIKey(api_key); err != nil {
+		if errors.Is(err, errInvalidAPIKey) {
+			fmt.Println("\nInvalid BART API key\n ")
+			os.Exit(exitInvalidKey)
+		}
+		fmt.Printf("\nCould not reach the BART API, check your network connection: %v\n", err)
+		os.Exit(exitUnreachable)
+	}
+
+	//	One-shot JSON of departures instead of the TUI
+	if *jsonOut {
+		if len(args) == 0 {
+			fmt.Println("\n--json requires at least one station abbreviation, e.g. bart-schedule --json POWL EMBR\n ")
+			os.Exit(exitUsage)
+		}
+		if *watch {
+			stop := make(chan struct{})
+			defer quitOnSignals(func() { close(stop) })()
+			watchJSON(os.Stdout, api_key, args, *refresh, stop)
+			return
+		}
+		if err := writeJSON(os.Stdout, api_key, args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error fetching departures: %v\n", err)
+			os.Exit(exitFetchFailed)
+		}
+		return
+	}
+	if *watch {
+		fmt.Println("\n--watch only works with --json, e.g. bart-schedule --json --watch POWL\n ")
+		os.Exit(exitUsage)
 	}
 
-	args := os.Args[1:]
+	//	One-shot CSV of departures instead of the TUI
+	if *csvOut {
+		if len(args) == 0 {
+			fmt.Println("\n--csv requires at least one station abbreviation, e.g. bart-schedule --csv POWL EMBR\n ")
+			os.Exit(exitUsage)
+		}
+		if err := writeCSV(os.Stdout, api_key, args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error fetching departures: %v\n", err)
+			os.Exit(exitFetchFailed)
+		}
+		return
+	}
+
+	//	Silent check for scripts, answered through the exit code
+	if *exitCode {
+		if len(args) == 0 || *within < 0 {
+			fmt.Fprintln(os.Stderr, "--exit-code requires a station abbreviation and a non-negative --within, e.g. bart-schedule --exit-code --within 5 POWL")
+			os.Exit(exitUsage)
+		}
+		deps, err := getDepartures(api_key, strings.ToUpper(args[0]), "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error fetching departures: %v\n", err)
+			os.Exit(exitFetchFailed)
+		}
+		os.Exit(leaveExitCode(deps, *to, *within))
+	}
+
+	//	One-shot compact summary instead of the TUI
+	if *compact {
+		if len(args) == 0 {
+			fmt.Println("\n--compact requires a station abbreviation, e.g. bart-schedule --compact POWL\n ")
+			os.Exit(exitUsage)
+		}
+		origin := strings.ToUpper(args[0])
+		deps, err := getDepartures(api_key, origin, "")
+		if err != nil {
+			fmt.Printf("\nError fetching departures for %s: %v\n", origin, err)
+			os.Exit(exitFetchFailed)
+		}
+		for _, line := range compactDepartures(origin, deps) {
+			fmt.Println(line)
+		}
+		return
+	}
+
+	m := initialModel(api_key, args)
+	m.notify = notify
+	m.refresh = *refresh
+	m.terse = *terse
+	m.nextOnly = *nextOnly
+	m.maxPerDest = *maxPerDest
+	m.allDirections = *allDirections
+	m.walk = *walk
+	m.histogram = *histogram
+	if *columns != "" {
+		cols, err := parseColumns(*columns)
+		if err != nil {
+			fmt.Printf("\nInvalid --columns: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		m.columns = cols
+	}
+	if *near != "" {
+		loc, err := parseLocation(*near)
+		if err != nil {
+			fmt.Printf("\nInvalid --near: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		m.near = &loc
+	}
+	m.confirmQuit = *confirmQuit
+	m.autoSelect = *autoSelect
+	m.wrapCursor = *wrapCursor
+	m.withScheduled = *withScheduled
+	if *once {
+		if len(args) == 0 || *onceDelay <= 0 {
+			fmt.Println("\n--once requires a station abbreviation and a positive --once-delay, e.g. bart-schedule --once POWL\n ")
+			os.Exit(exitUsage)
+		}
+		m.once = *onceDelay
+	}
+	m.experimentalMap = *experimentalMap
+	m.leaving = cfg.LeavingLabel
+	m.platformLabels = make(map[string]map[string]string)
+	for abbr, labels := range cfg.PlatformLabels {
+		m.platformLabels[strings.ToUpper(abbr)] = labels
+	}
+	m.minuteSuffix = cfg.MinuteSuffix
+	if len(cfg.Keymap) > 0 {
+		km, err := newKeyMap(cfg.Keymap)
+		if err != nil {
+			fmt.Printf("\nInvalid keymap in config: %v\n", err)
+			os.Exit(exitConfig)
+		}
+		m.keys = &km
+	}
+	if *preset != "" {
+		for name, abbrs := range cfg.Presets {
+			presets[name] = abbrs
+		}
+		abbrs, ok := presets[*preset]
+		if !ok {
+			fmt.Printf("\nUnknown preset %q\n", *preset)
+			os.Exit(exitUsage)
+		}
+		for _, abbr := range abbrs {
+			m.board = append(m.board, strings.ToUpper(abbr))
+		}
+	}
+	m.minMinutes = *minMinutes
+	m.showLeaving = *showLeaving
+	m.arriveAt = strings.ToUpper(*arriveAt)
+	m.plainCursor = *plainCursor || *plain
+	m.listWidth, m.gap, m.fitList = *listWidth, *gap, *listWidth == 0
+	if !*noColor && !*plain && os.Getenv("NO_COLOR") == "" {
+		if *themeName == "" {
+			*themeName = autoTheme(os.Getenv("COLORFGBG"), lipgloss.HasDarkBackground)
+		}
+		th, ok := themes[*themeName]
+		if !ok {
+			fmt.Printf("\nUnknown theme %q, expected dark, light or bart\n", *themeName)
+			os.Exit(exitUsage)
+		}
+		m.theme = th
+		m.lineColors = true
+		m.colorProfile = termenv.EnvColorProfile()
+		m.banner = true
+	}
+	m.symbols = *symbols
+	m.remember = !*noRestore
+	if state, err := loadState(); err == nil {
+		m.favorites = state.Favorites
+		if m.remember {
+			m.lastStation = state.LastStation
+			m.direction = state.Direction
+			m.sortByTime = state.SortByTime
+			m.stationSort = max(slices.Index(stationSorts, state.StationSort), 0)
+		}
+	}
+
+	//	Board of just the starred stations
+	if *favorites {
+		if len(m.favorites) == 0 {
+			fmt.Println("\nNo favorites yet: press * on a station in the list to star it\n ")
+			os.Exit(exitUsage)
+		}
+		m.board = m.favorites
+	}
+
+	//	One-shot scheduled departures for planning a trip later in the day
+	if *atTime != "" {
+		if len(args) == 0 {
+			fmt.Println("\n--at requires a station abbreviation, e.g. bart-schedule --at 18:00 POWL\n ")
+			os.Exit(exitUsage)
+		}
+		t, err := parseAtTime(*atTime, clock())
+		if err != nil {
+			fmt.Printf("\nInvalid --at: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		origin := strings.ToUpper(args[0])
+		deps, err := getScheduledDepartures(api_key, origin, t)
+		if err != nil {
+			fmt.Printf("\nError fetching the schedule for %s: %v\n", origin, err)
+			os.Exit(exitFetchFailed)
+		}
+		if len(deps) == 0 {
+			fmt.Printf("No trains scheduled from %s in the hour after %s\n", origin, t.Format("3:04 PM"))
+			return
+		}
+		fmt.Printf("%s Scheduled departures after %s\n\n%s", origin, t.Format("3:04 PM"), formatDepartures(deps, renderOptions{columns: m.columns}))
+		return
+	}
+
+	//	Not a terminal (piped or redirected): print once instead of running the TUI
+	if !isTerminal(os.Stdout) {
+		var platforms map[string]string
+		if len(args) > 0 {
+			platforms = m.platformLabels[strings.ToUpper(args[0])]
+		}
+		if err := printOnce(os.Stdout, api_key, args, renderOptions{terse: *terse, minMinutes: *minMinutes, showLeaving: *showLeaving, nextOnly: *nextOnly, maxPerDest: *maxPerDest, allDirections: *allDirections, columns: m.columns, leaving: m.leaving, minuteSuffix: m.minuteSuffix, platforms: platforms}); err != nil {
+			fmt.Printf("\nError: %v\n", err)
+			os.Exit(exitFetchFailed)
+		}
+		return
+	}
 
 	//	Start Bubble Tea program
-	//	consider removal of tea.WithAltScreen
-	p := tea.NewProgram(initialModel(api_key, args), tea.WithAltScreen())
+	//	Focus reports let the auto-refresh pause while the terminal is in the background
+	opts := []tea.ProgramOption{tea.WithReportFocus()}
+	if !*noAltScreen {
+		opts = append(opts, tea.WithAltScreen())
+	}
+	if m.once == 0 {
+		m.nextRefresh = time.Now().Add(m.refreshInterval())
+	}
+	//	Our own handler also covers SIGHUP, for when the terminal is closed
+	opts = append(opts, tea.WithoutSignalHandler())
+	p := tea.NewProgram(m, opts...)
+	defer quitOnSignals(p.Quit)()
 	if err := p.Start(); err != nil {
 		fmt.Printf("\nError starting program: %v\n", err)
-		os.Exit(1)
+		if logFile != nil {
+			logFile.Close()
+		}
+		os.Exit(exitFailed)
+	}
+}
+
+// Picks the dark or light theme for the terminal background: from
+// COLORFGBG ("15;0" is light text on black) when set, else by asking the
+// terminal
+func autoTheme(colorfgbg string, hasDarkBackground func() bool) string {
+	if i := strings.LastIndex(colorfgbg, ";"); i >= 0 {
+		//	ANSI colors 7 and 9-15 are light backgrounds
+		if bg, err := strconv.Atoi(colorfgbg[i+1:]); err == nil {
+			if bg == 7 || (bg >= 9 && bg <= 15) {
+				return "light"
+			}
+			return "dark"
+		}
+	}
+	if hasDarkBackground() {
+		return "dark"
+	}
+	return "light"
+}
+
+// Calls quit on SIGINT, SIGTERM or SIGHUP so the program can shut down
+// cleanly and restore the terminal; returns a func to stop listening
+func quitOnSignals(quit func()) func() {
+	sigs := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		select {
+		case sig := <-sigs:
+			logger.Info("quitting on signal", "signal", sig)
+			quit()
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
 	}
 }
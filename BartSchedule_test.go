@@ -2,14 +2,33 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"reflect"
+	"slices"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+	"golang.org/x/time/rate"
 )
 
+// Pins the clock to midday so late-night annotations don't depend on when
+// the tests run
+func TestMain(m *testing.M) {
+	clock = func() time.Time { return time.Date(2026, 10, 16, 12, 0, 0, 0, time.Local) }
+	os.Exit(m.Run())
+}
+
 func TestInitialModel(t *testing.T) {
 	result := initialModel("123456789", []string{"one", "two", "three"})
 	if result.message == "" {
@@ -95,7 +114,7 @@ func TestGetDepartures(t *testing.T) {
 	}
 	defer func() { httpGet = oldGet }()
 
-	deps, err := getDepartures("fake_key", "POWL")
+	deps, err := getDepartures("fake_key", "POWL", "")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -208,8 +227,11 @@ func TestUpdateEnter(t *testing.T) {
 		stations: []station{{Name: "Test Station", Abbr: "TEST"}},
 	}
 
-	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
-	m2 := updated.(model)
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if view := updated.(model).View(); !strings.Contains(view, "Loading departures for Test Station...") {
+		t.Errorf("expected a loading placeholder while fetching, got %q", view)
+	}
+	m2 := loaded(t, updated, cmd)
 
 	if m2.info == "" {
 		t.Fatalf("expected departures info, got empty string")
@@ -218,3 +240,2903 @@ func TestUpdateEnter(t *testing.T) {
 		t.Errorf("expected departures to include Dxxx, got %q", m2.info)
 	}
 }
+
+func TestGetRoutes(t *testing.T) {
+	mockResponse := `{
+		"root": {
+			"routes": {
+				"route": [
+					{"name": "Antioch to SFIA/Millbrae", "abbr": "ANTC-SFIA", "number": "1", "hexcolor": "#ffff33", "color": "YELLOW"},
+					{"name": "Richmond to Berryessa", "abbr": "RICH-BERY", "number": "3", "hexcolor": "#ff9933", "color": "ORANGE"}
+				]
+			}
+		}
+	}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mockResponse))
+	}))
+	defer server.Close()
+
+	oldGet := httpGet
+	httpGet = func(url string) (*http.Response, error) {
+		return http.Get(server.URL)
+	}
+	defer func() { httpGet = oldGet }()
+
+	routes, err := getRoutes("fake_key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("expected 2 routes, got %d", len(routes))
+	}
+	if routes[0].Color != "YELLOW" {
+		t.Errorf("expected first route color=YELLOW, got %s", routes[0].Color)
+	}
+	from, to := routes[1].endpoints()
+	if from != "RICH" || to != "BERY" {
+		t.Errorf("expected endpoints RICH/BERY, got %s/%s", from, to)
+	}
+}
+
+func TestGetRouteInfo(t *testing.T) {
+	mockResponse := `{
+		"root": {
+			"routes": {
+				"route": {
+					"name": "Antioch to SFIA/Millbrae",
+					"config": {"station": ["ANTC", "PCTR", "PITT"]}
+				}
+			}
+		}
+	}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mockResponse))
+	}))
+	defer server.Close()
+
+	oldGet := httpGet
+	httpGet = func(url string) (*http.Response, error) {
+		return http.Get(server.URL)
+	}
+	defer func() { httpGet = oldGet }()
+
+	stops, err := getRouteInfo("fake_key", 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stops) != 3 || stops[0] != "ANTC" || stops[2] != "PITT" {
+		t.Errorf("expected stops [ANTC PCTR PITT], got %v", stops)
+	}
+}
+
+func TestExportDepartures(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	deps := map[string][]departureInfo{
+		"Dublin": {{Minutes: "4", Platform: "1", Direction: "South"}},
+	}
+
+	name, err := exportDepartures(deps, "csv", renderOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "bart-departures-" + clock().Format("20060102-150405") + ".csv"; name != want {
+		t.Errorf("expected file %s, got %s", want, name)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("expected exported file %s: %v", name, err)
+	}
+	want := "destination,minutes,platform,direction\nDublin,4,1,South\n"
+	if string(data) != want {
+		t.Errorf("expected CSV %q, got %q", want, string(data))
+	}
+
+	if _, err := exportDepartures(deps, "pdf", renderOptions{}); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+
+	//	Text exports match what's on screen, e.g. with --terse
+	name, err = exportDepartures(deps, "txt", renderOptions{terse: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err = os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("expected exported file %s: %v", name, err)
+	}
+	if !strings.Contains(string(data), "4 min") || strings.Contains(string(data), "in 4 min") {
+		t.Errorf("expected terse minutes in the text export, got %q", string(data))
+	}
+}
+
+func TestParseNotifyRule(t *testing.T) {
+	rule, err := parseNotifyRule("powl:Dublin:6")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rule.Station != "POWL" || rule.Destination != "Dublin" || rule.Minutes != 6 {
+		t.Errorf("unexpected rule %+v", rule)
+	}
+
+	for _, bad := range []string{"POWL", "POWL:Dublin", "POWL:Dublin:x", ":Dublin:6"} {
+		if _, err := parseNotifyRule(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestCheckNotificationsOncePerTrain(t *testing.T) {
+	mockResponse := `{
+		"root": {
+			"station": [{
+				"abbr": "POWL",
+				"etd": [{
+					"destination": "Dublin/Pleasanton",
+					"estimate": [
+						{"minutes": "5", "platform": "2"},
+						{"minutes": "20", "platform": "2"}
+					]
+				}]
+			}]
+		}
+	}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mockResponse))
+	}))
+	defer server.Close()
+
+	oldGet := httpGet
+	httpGet = func(url string) (*http.Response, error) {
+		return http.Get(server.URL)
+	}
+	defer func() { httpGet = oldGet }()
+
+	var sent []string
+	oldNotify := notifyFunc
+	notifyFunc = func(title, body string) error {
+		sent = append(sent, title)
+		return nil
+	}
+	defer func() { notifyFunc = oldNotify }()
+
+	m := model{notify: []notifyRule{{Station: "POWL", Destination: "dublin", Minutes: 6}}}
+	check := func(now time.Time) {
+		updated, _ := m.Update(m.checkNotifications(now)())
+		m = updated.(model)
+	}
+	now := time.Now()
+	check(now)
+	check(now.Add(5 * time.Second))
+
+	if len(sent) != 1 {
+		t.Fatalf("expected exactly 1 notification, got %d: %v", len(sent), sent)
+	}
+
+	//	Trains that have left are forgotten rather than kept forever
+	m.notify = nil
+	check(now.Add(time.Hour))
+	if len(m.notified) != 0 {
+		t.Errorf("expected departed trains forgotten, got %v", m.notified)
+	}
+}
+
+// Serves body for every request made through httpGet during the test
+func serveJSON(t *testing.T, body string) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	oldGet := httpGet
+	httpGet = func(url string) (*http.Response, error) {
+		return http.Get(server.URL)
+	}
+	t.Cleanup(func() { httpGet = oldGet })
+}
+
+func TestGetDeparturesSingleOrMultipleEstimates(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     int
+	}{
+		{
+			name: "single estimate as object",
+			response: `{"root": {"station": [{"abbr": "POWL", "etd": [{
+				"destination": "Dublin",
+				"estimate": {"minutes": "4", "platform": "2"}
+			}]}]}}`,
+			want: 1,
+		},
+		{
+			name: "multiple estimates as array",
+			response: `{"root": {"station": [{"abbr": "POWL", "etd": [{
+				"destination": "Dublin",
+				"estimate": [{"minutes": "4", "platform": "2"}, {"minutes": "19", "platform": "2"}]
+			}]}]}}`,
+			want: 2,
+		},
+		{
+			name: "single station and etd as objects",
+			response: `{"root": {"station": {"abbr": "POWL", "etd": {
+				"destination": "Dublin",
+				"estimate": {"minutes": "Leaving", "platform": "2"}
+			}}}}`,
+			want: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serveJSON(t, tt.response)
+
+			deps, err := getDepartures("fake_key", "POWL", "")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(deps["Dublin"]) != tt.want {
+				t.Errorf("expected %d departures for Dublin, got %v", tt.want, deps)
+			}
+		})
+	}
+}
+
+func TestCompactDepartures(t *testing.T) {
+	deps := map[string][]departureInfo{
+		"Dublin/Pleasanton": {{Minutes: "12", Platform: "2"}, {Minutes: "4", Platform: "1"}},
+		"Antioch":           {{Minutes: "Leaving", Platform: "1"}},
+		"Richmond":          {{Minutes: "unknown", Platform: "2"}},
+		"SF Airport":        {{Minutes: "9"}},
+	}
+
+	lines := compactDepartures("POWL", deps)
+	want := []string{
+		"POWL → Antioch: 0m (Plat 1)",
+		"POWL → Dublin/Pleasanton: 4m (Plat 1)",
+		"POWL → SF Airport: 9m",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("expected %d lines, got %v", len(want), lines)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d: expected %q, got %q", i, want[i], lines[i])
+		}
+	}
+}
+
+func TestValidateAPIKey(t *testing.T) {
+	t.Run("valid key", func(t *testing.T) {
+		serveJSON(t, `{"root": {"stations": {"station": [{"name": "Powell St.", "abbr": "POWL"}]}, "message": ""}}`)
+		if err := validateAPIKey("good_key"); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("rejected key", func(t *testing.T) {
+		serveJSON(t, `{"root": {"message": {"error": {"text": "Invalid key", "details": "The api key was missing or invalid."}}}}`)
+		if err := validateAPIKey("bad_key"); !errors.Is(err, errInvalidAPIKey) {
+			t.Errorf("expected errInvalidAPIKey, got %v", err)
+		}
+	})
+
+	t.Run("unreachable API", func(t *testing.T) {
+		oldGet := httpGet
+		httpGet = func(url string) (*http.Response, error) {
+			return nil, errors.New("dial tcp: connection refused")
+		}
+		defer func() { httpGet = oldGet }()
+
+		err := validateAPIKey("any_key")
+		if err == nil || errors.Is(err, errInvalidAPIKey) {
+			t.Errorf("expected a network error, got %v", err)
+		}
+	})
+}
+
+func TestGetArrivals(t *testing.T) {
+	serveJSON(t, `{"root": {"schedule": {"request": {"trip": [
+		{"@origin": "POWL", "@destination": "DUBL", "@origTimeMin": "5:02 PM", "@destTimeMin": "5:40 PM"},
+		{"@origin": "POWL", "@destination": "DUBL", "@origTimeMin": "5:17 PM", "@destTimeMin": "5:55 PM"}
+	]}}}}`)
+
+	trips, err := getArrivals("fake_key", "POWL", "DUBL")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(trips) != 2 {
+		t.Fatalf("expected 2 trips, got %d", len(trips))
+	}
+	if trips[0].DestTime != "5:40 PM" || trips[1].OrigTime != "5:17 PM" {
+		t.Errorf("unexpected trips %+v", trips)
+	}
+}
+
+func TestRestoreLastStation(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	serveJSON(t, `{"root": {"station": [{"abbr": "POWL", "etd": [{
+		"destination": "Dublin", "estimate": [{"minutes": "4", "platform": "2"}]
+	}]}]}}`)
+
+	if err := saveState(appState{LastStation: "POWL"}); err != nil {
+		t.Fatalf("unexpected error saving state: %v", err)
+	}
+	state, err := loadState()
+	if err != nil || state.LastStation != "POWL" {
+		t.Fatalf("expected saved station POWL, got %q (err %v)", state.LastStation, err)
+	}
+
+	m := model{remember: true, lastStation: state.LastStation}
+	updated, cmd := m.Update([]station{{Name: "Embarcadero", Abbr: "EMBR"}, {Name: "Powell St.", Abbr: "POWL"}})
+	m2 := loaded(t, updated, cmd)
+
+	if m2.cursor != 1 {
+		t.Errorf("expected cursor on POWL (1), got %d", m2.cursor)
+	}
+	if !strings.Contains(m2.info, "Dublin") {
+		t.Errorf("expected restored departures, got %q", m2.info)
+	}
+}
+
+func TestThemes(t *testing.T) {
+	for _, name := range []string{"dark", "light", "bart"} {
+		if _, ok := themes[name]; !ok {
+			t.Errorf("expected theme %q to be defined", name)
+		}
+	}
+
+	//	The zero theme (--no-color) renders without any escape codes
+	m := model{stations: []station{{Name: "Powell St.", Abbr: "POWL"}}}
+	if view := m.View(); strings.Contains(view, "\x1b[") {
+		t.Errorf("expected plain output without a theme, got %q", view)
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	tests := []struct {
+		s     string
+		width int
+		want  []string
+	}{
+		{"short", 20, []string{"short"}},
+		{"Dublin/Pleasanton via a very long detour:", 20, []string{"Dublin/Pleasanton", "via a very long", "detour:"}},
+		{"  5 min | Platform 1", 12, []string{"  5 min |", "  Platform 1"}},
+		{"Supercalifragilistic", 8, []string{"Supercal", "ifragili", "stic"}},
+		{"東京東京東京東京", 5, []string{"東京", "東京", "東京", "東京"}},
+		{"東京", 1, []string{"東", "京"}},
+		{"no width", 0, []string{"no width"}},
+	}
+
+	for _, tt := range tests {
+		got := wrapText(tt.s, tt.width)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("wrapText(%q, %d) = %q, want %q", tt.s, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateCopyStation(t *testing.T) {
+	var copied string
+	oldClipboard := clipboardFunc
+	clipboardFunc = func(text string) error {
+		copied = text
+		return nil
+	}
+	defer func() { clipboardFunc = oldClipboard }()
+
+	m := model{cursor: 1, stations: []station{{Abbr: "EMBR"}, {Abbr: "POWL"}}}
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")})
+
+	if copied != "POWL" {
+		t.Errorf("expected POWL copied, got %q", copied)
+	}
+	if updated.(model).status != "Copied POWL" {
+		t.Errorf("expected confirmation in status, got %q", updated.(model).status)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("expected no error without a config file, got %v", err)
+	}
+	if !reflect.DeepEqual(cfg, config{}) {
+		t.Errorf("expected empty config, got %+v", cfg)
+	}
+
+	cfgDir, _ := configDir()
+	os.MkdirAll(cfgDir, 0755)
+	os.WriteFile(cfgDir+"/config.json", []byte(`{"api_key": "abc", "refresh": 10, "theme": "bart", "default_station": "POWL"}`), 0644)
+
+	cfg, err = loadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := config{APIKey: "abc", Refresh: 10, Theme: "bart", DefaultStation: "POWL"}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("expected %+v, got %+v", want, cfg)
+	}
+}
+
+func TestTickSkipsUnchangedDepartures(t *testing.T) {
+	serveJSON(t, `{"root": {"station": [{"abbr": "POWL", "etd": [{
+		"destination": "Dublin", "estimate": [{"minutes": "4", "platform": "2"}]
+	}]}]}}`)
+
+	m := model{
+		args:       []string{"POWL"},
+		departures: map[string][]departureInfo{"Dublin": {{Minutes: "4", Platform: "2"}}},
+		info:       "unchanged board",
+	}
+	updated, cmd := m.Update(tickMsg{})
+	if got := loaded(t, updated, cmd).info; got != "unchanged board" {
+		t.Errorf("expected info to be left alone, got %q", got)
+	}
+
+	m.departures = map[string][]departureInfo{"Dublin": {{Minutes: "5", Platform: "2"}}}
+	updated, cmd = m.Update(tickMsg{})
+	if got := loaded(t, updated, cmd).info; !strings.Contains(got, "4 min") {
+		t.Errorf("expected info rebuilt with new departures, got %q", got)
+	}
+}
+
+func TestLogRequests(t *testing.T) {
+	var buf strings.Builder
+	oldLogger := logger
+	logger = slog.New(slog.NewTextHandler(&buf, nil))
+	defer func() { logger = oldLogger }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+
+	get := logRequests(http.Get)
+	resp, err := get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if !strings.Contains(buf.String(), "status=418") || !strings.Contains(buf.String(), server.URL) {
+		t.Errorf("expected request URL and status in log, got %q", buf.String())
+	}
+}
+
+func TestPrintOnce(t *testing.T) {
+	serveJSON(t, `{"root": {"station": [{"abbr": "POWL", "etd": [{
+		"destination": "Dublin", "estimate": [{"minutes": "4", "platform": "2"}]
+	}]}]}}`)
+
+	var out strings.Builder
+	if err := printOnce(&out, "fake_key", []string{"powl"}, renderOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "POWL Departures") || !strings.Contains(out.String(), "4 min | Platform 2") {
+		t.Errorf("unexpected output %q", out.String())
+	}
+}
+
+func TestUpdateRefreshDeparturesOnly(t *testing.T) {
+	serveJSON(t, `{"root": {"station": [{"abbr": "POWL", "etd": [{
+		"destination": "Dublin", "estimate": [{"minutes": "3", "platform": "2"}]
+	}]}]}}`)
+
+	stations := []station{{Name: "Embarcadero", Abbr: "EMBR"}, {Name: "Powell St.", Abbr: "POWL"}}
+	m := model{cursor: 0, stations: stations, viewing: stations[1], info: "old"}
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeySpace, Runes: []rune(" ")})
+	m2 := loaded(t, updated, cmd)
+
+	if m2.cursor != 0 || len(m2.stations) != 2 {
+		t.Errorf("expected cursor and station list kept, got cursor=%d stations=%d", m2.cursor, len(m2.stations))
+	}
+	if !strings.Contains(m2.info, "Powell St.") || !strings.Contains(m2.info, "3 min") {
+		t.Errorf("expected refreshed Powell departures, got %q", m2.info)
+	}
+
+	//	The same for a locked station
+	m = model{args: []string{"POWL"}, selectedName: "Powell St.", info: "old"}
+	updated, cmd = m.Update(tea.KeyMsg{Type: tea.KeySpace, Runes: []rune(" ")})
+	m2 = loaded(t, updated, cmd)
+	if !m2.locked() {
+		t.Errorf("expected to stay on the locked station")
+	}
+	if !strings.Contains(m2.info, "Powell St.") || !strings.Contains(m2.info, "3 min") {
+		t.Errorf("expected refreshed Powell departures, got %q", m2.info)
+	}
+
+	//	And for a board, every station on it
+	m = model{board: []string{"POWL"}}
+	_, cmd = m.Update(tea.KeyMsg{Type: tea.KeySpace, Runes: []rune(" ")})
+	if cmd == nil {
+		t.Fatal("expected a board refresh")
+	}
+	if msg, ok := cmd().(boardMsg); !ok || len(msg.deps["POWL"]) == 0 {
+		t.Errorf("expected refreshed board departures, got %v", msg)
+	}
+}
+
+func TestMarkStationsForBoard(t *testing.T) {
+	serveJSON(t, `{"root": {"station": [{"abbr": "POWL", "etd": [{
+		"destination": "Dublin", "estimate": [{"minutes": "3", "platform": "2"}]
+	}]}]}}`)
+	stations := []station{{Name: "Embarcadero", Abbr: "EMBR"}, {Name: "Montgomery St.", Abbr: "MONT"}, {Name: "Powell St.", Abbr: "POWL"}}
+	m := model{stations: stations}
+
+	if m = typeText(m, "b"); len(m.board) != 0 {
+		t.Fatal("expected no board before marking stations")
+	}
+
+	//	Mark POWL, then EMBR
+	m = typeText(m, "ss+ww+")
+	if !m.selected["POWL"] || !m.selected["EMBR"] || m.selected["MONT"] {
+		t.Fatalf("expected EMBR and POWL marked, got %v", m.selected)
+	}
+	if view := m.View(); !strings.Contains(view, "Powell St., (POWL) ✓") {
+		t.Errorf("expected a mark next to POWL, got %q", view)
+	}
+	m = typeText(m, "++")
+	if !m.selected["EMBR"] || len(m.selected) != 2 {
+		t.Errorf("expected + twice to leave EMBR marked, got %v", m.selected)
+	}
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("b")})
+	m = updated.(model)
+	if !reflect.DeepEqual(m.board, []string{"EMBR", "POWL"}) || cmd == nil {
+		t.Fatalf("expected a board of EMBR and POWL in list order, got %v", m.board)
+	}
+	updated, _ = m.Update(cmd())
+	if view := updated.(model).View(); !strings.Contains(view, "Dublin") {
+		t.Errorf("expected board departures, got %q", view)
+	}
+}
+
+func TestFormatDeparturesEmpty(t *testing.T) {
+	got := formatDepartures(map[string][]departureInfo{}, renderOptions{})
+	if !strings.Contains(got, "No trains scheduled right now") {
+		t.Errorf("expected friendly empty message, got %q", got)
+	}
+}
+
+func TestHumanizeMinutes(t *testing.T) {
+	tests := map[string]string{
+		"Leaving": "Leaving now",
+		"1":       "in 1 min",
+		"5":       "in 5 min",
+		"":        "",
+	}
+	for in, want := range tests {
+		if got := (renderOptions{}).humanizeMinutes(in); got != want {
+			t.Errorf("humanizeMinutes(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	deps := map[string][]departureInfo{"Dublin": {{Minutes: "5", Platform: "1"}}}
+	if got := formatDepartures(deps, renderOptions{terse: true}); !strings.Contains(got, "   5 min | Platform 1") {
+		t.Errorf("expected terse form with --terse, got %q", got)
+	}
+}
+
+func TestViewNarrowTerminal(t *testing.T) {
+	m := model{
+		width:    60,
+		stations: []station{{Name: "Powell St.", Abbr: "POWL"}},
+		info:     "Powell St.\n\nDublin:\n   in 4 min | Platform 2\n",
+	}
+	view := m.View()
+
+	for _, line := range strings.Split(view, "\n") {
+		if strings.HasSuffix(line, " ") {
+			t.Errorf("expected no trailing padding in narrow layout, got %q", line)
+		}
+	}
+	if strings.Index(view, "Powell St., (POWL)") > strings.Index(view, "Departures:") {
+		t.Errorf("expected stations listed before departures, got %q", view)
+	}
+}
+
+func TestViewColumnWidths(t *testing.T) {
+	m := model{
+		stations:  []station{{Name: "Powell St.", Abbr: "POWL"}},
+		info:      "Powell St.\n\nDublin:\n   in 4 min | Platform 2\n",
+		listWidth: 30,
+		gap:       4,
+	}
+	//	Departures start after the list column and the gap
+	if !strings.Contains(m.View(), "BART Stations:"+strings.Repeat(" ", 30-len("BART Stations:")+4)+"Departures:") {
+		t.Errorf("expected a 30 column list and a 4 space gap, got:\n%s", m.View())
+	}
+
+	m.listWidth, m.fitList = 0, true
+	if w, _ := m.columnWidths(); w != lipgloss.Width("    Powell St., (POWL) ★9 ✓") {
+		t.Errorf("expected the list to fit the longest station, got width %d", w)
+	}
+
+	//	Settings too wide for the terminal stack the columns instead
+	m.fitList, m.listWidth, m.width = false, 100, 120
+	if view := m.View(); strings.Index(view, "Powell St., (POWL)") > strings.Index(view, "Departures:") {
+		t.Errorf("expected stations listed before departures, got:\n%s", view)
+	}
+}
+
+func TestGetDeparturesDirection(t *testing.T) {
+	var requested string
+	oldGet := httpGet
+	httpGet = func(url string) (*http.Response, error) {
+		requested = url
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"root": {}}`))}, nil
+	}
+	defer func() { httpGet = oldGet }()
+
+	if _, err := getDepartures("fake_key", "POWL", "s"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(requested, "&dir=s") {
+		t.Errorf("expected dir=s in request, got %q", requested)
+	}
+
+	getDepartures("fake_key", "POWL", "")
+	if strings.Contains(requested, "dir=") {
+		t.Errorf("expected no direction in request, got %q", requested)
+	}
+}
+
+func TestStationStats(t *testing.T) {
+	deps := map[string][]departureInfo{
+		"Dublin":   {{Minutes: "4", Platform: "2"}, {Minutes: "19", Platform: "2"}},
+		"Antioch":  {{Minutes: "7", Platform: "1"}},
+		"Richmond": {{Minutes: "12", Platform: "1"}},
+	}
+	if got := stationStats(deps); got != "3 destinations, 2 platforms" {
+		t.Errorf("unexpected stats %q", got)
+	}
+
+	single := map[string][]departureInfo{"Dublin": {{Minutes: "4", Platform: "2"}}}
+	if got := stationStats(single); got != "1 destination, 1 platform" {
+		t.Errorf("unexpected stats %q", got)
+	}
+}
+
+func TestTripToICS(t *testing.T) {
+	ics := string(tripToICS(trip{
+		Origin:      "POWL",
+		Destination: "DUBL",
+		OrigDate:    "10/16/2026",
+		OrigTime:    "5:02 PM",
+		DestDate:    "10/16/2026",
+		DestTime:    "5:40 PM",
+	}))
+
+	for _, want := range []string{
+		"BEGIN:VTIMEZONE\r\nTZID:America/Los_Angeles\r\n",
+		"END:VTIMEZONE\r\nBEGIN:VEVENT\r\n",
+		"DTSTAMP:" + clock().UTC().Format("20060102T150405") + "Z\r\n",
+		"DTSTART;TZID=America/Los_Angeles:20261016T170200\r\n",
+		"DTEND;TZID=America/Los_Angeles:20261016T174000\r\n",
+		"SUMMARY:BART POWL → DUBL\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(ics, want) {
+			t.Errorf("expected %q in calendar, got %q", want, ics)
+		}
+	}
+}
+
+func TestFormatDeparturesMinMinutes(t *testing.T) {
+	deps := map[string][]departureInfo{
+		"Dublin":  {{Minutes: "Leaving", Platform: "2"}, {Minutes: "1", Platform: "2"}, {Minutes: "8", Platform: "2"}},
+		"Antioch": {{Minutes: "1", Platform: "1"}},
+	}
+
+	got := formatDepartures(deps, renderOptions{minMinutes: 2})
+	if strings.Contains(got, "in 1 min") || strings.Contains(got, "Leaving") {
+		t.Errorf("expected trains under 2 min hidden, got %q", got)
+	}
+	if !strings.Contains(got, "in 8 min") || strings.Contains(got, "Antioch:") {
+		t.Errorf("expected only the 8 min Dublin train, got %q", got)
+	}
+
+	got = formatDepartures(deps, renderOptions{minMinutes: 2, showLeaving: true})
+	if !strings.Contains(got, "Leaving now") {
+		t.Errorf("expected Leaving kept with showLeaving, got %q", got)
+	}
+
+	got = formatDepartures(deps, renderOptions{minMinutes: 2, showAll: true})
+	if !strings.Contains(got, "in 1 min") {
+		t.Errorf("expected all trains when toggled off, got %q", got)
+	}
+}
+
+// getDepartures assumes one origin per request: when BART returns several
+// stations, only the requested one is used so destinations don't collide.
+func TestGetDeparturesMultipleStations(t *testing.T) {
+	serveJSON(t, `{"root": {"station": [
+		{"abbr": "EMBR", "etd": [{"destination": "Dublin", "estimate": [{"minutes": "2", "platform": "2"}]}]},
+		{"abbr": "POWL", "etd": [{"destination": "Dublin", "estimate": [{"minutes": "5", "platform": "2"}]}]}
+	]}}`)
+
+	deps, err := getDepartures("fake_key", "powl", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(deps["Dublin"]) != 1 || deps["Dublin"][0].Minutes != "5" {
+		t.Errorf("expected only POWL's Dublin departure, got %v", deps)
+	}
+
+	if _, err := getDepartures("fake_key", "MONT", ""); err == nil {
+		t.Errorf("expected error when the requested station is missing")
+	}
+}
+
+func TestUpdateEscLeavesLockedView(t *testing.T) {
+	m := model{args: []string{"powl"}, info: "Powell St. Departures", selectedName: "Powell St."}
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m2 := updated.(model)
+
+	if len(m2.args) != 0 || m2.info != "" {
+		t.Errorf("expected args and info cleared, got args=%v info=%q", m2.args, m2.info)
+	}
+	if m2.lastStation != "POWL" {
+		t.Errorf("expected list to reopen on POWL, got %q", m2.lastStation)
+	}
+	if cmd == nil {
+		t.Errorf("expected fetchStations command, got nil")
+	}
+}
+
+func TestBoardView(t *testing.T) {
+	m := model{
+		board:    []string{"EMBR", "POWL"},
+		stations: []station{{Name: "Powell St.", Abbr: "POWL"}},
+	}
+	updated, _ := m.Update(boardMsg{
+		deps: map[string]map[string][]departureInfo{
+			"POWL": {"Dublin": {{Minutes: "4", Platform: "2"}}},
+		},
+		errs: map[string]error{"EMBR": errors.New("timeout")},
+	})
+	view := updated.(model).View()
+
+	for _, want := range []string{"EMBR", "Error: timeout", "Powell St. (POWL)", "in 4 min"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("expected %q on the board, got %q", want, view)
+		}
+	}
+
+	//	A failed refresh keeps the station's last good departures, marked stale
+	updated, _ = updated.Update(boardMsg{
+		deps: map[string]map[string][]departureInfo{},
+		errs: map[string]error{"EMBR": errors.New("timeout"), "POWL": errors.New("timeout")},
+	})
+	view = updated.(model).View()
+	if !strings.Contains(view, "⚠ stale") || !strings.Contains(view, "in 4 min") {
+		t.Errorf("expected POWL's old departures marked stale, got %q", view)
+	}
+}
+
+func TestGetDeparturesRetryEmpty(t *testing.T) {
+	responses := []string{
+		`{"root": {"station": []}}`,
+		`{"root": {"station": [{"abbr": "POWL", "etd": [{"destination": "Dublin", "estimate": [{"minutes": "4", "platform": "2"}]}]}]}}`,
+	}
+	calls := 0
+	oldGet := httpGet
+	httpGet = func(url string) (*http.Response, error) {
+		body := responses[min(calls, len(responses)-1)]
+		calls++
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}
+	defer func() { httpGet = oldGet }()
+
+	oldRetry, oldDelay := retryEmpty, retryEmptyDelay
+	defer func() { retryEmpty, retryEmptyDelay = oldRetry, oldDelay }()
+	retryEmptyDelay = 0
+
+	retryEmpty = false
+	deps, _ := getDepartures("fake_key", "POWL", "")
+	if len(deps) != 0 || calls != 1 {
+		t.Errorf("expected a single request and no departures without retry, got %d calls, %v", calls, deps)
+	}
+
+	calls = 0
+	retryEmpty = true
+	deps, _ = getDepartures("fake_key", "POWL", "")
+	if len(deps["Dublin"]) != 1 || calls != 2 {
+		t.Errorf("expected departures after one retry, got %d calls, %v", calls, deps)
+	}
+}
+
+func TestSortByMinutes(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{"leaving first", []string{"2", "Leaving", "10"}, []string{"Leaving", "2", "10"}},
+		{"numeric not lexical", []string{"10", "9", "2"}, []string{"2", "9", "10"}},
+		{"non-numeric last", []string{"unknown", "5", "Leaving"}, []string{"Leaving", "5", "unknown"}},
+		{"stable for equal keys", []string{"Leaving", "0"}, []string{"Leaving", "0"}},
+		{"empty", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deps []departureInfo
+			for _, m := range tt.in {
+				deps = append(deps, departureInfo{Minutes: m})
+			}
+			var got []string
+			for _, dep := range sortByMinutes(deps) {
+				got = append(got, dep.Minutes)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFormatDeparturesSortByTime(t *testing.T) {
+	deps := map[string][]departureInfo{
+		"Antioch": {{Minutes: "12", Platform: "1"}},
+		"Dublin":  {{Minutes: "9", Platform: "2"}, {Minutes: "Leaving", Platform: "2"}},
+	}
+	got := formatDepartures(deps, renderOptions{sortByTime: true})
+
+	if strings.Index(got, "Dublin:") > strings.Index(got, "Antioch:") {
+		t.Errorf("expected Dublin (leaving now) before Antioch, got %q", got)
+	}
+	if strings.Index(got, "Leaving now") > strings.Index(got, "in 9 min") {
+		t.Errorf("expected Leaving before 9 min, got %q", got)
+	}
+}
+
+func TestUpdateConfirmQuit(t *testing.T) {
+	m := model{confirmQuit: true}
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("q")})
+	m2 := updated.(model)
+	if cmd != nil {
+		t.Fatalf("expected first q not to quit")
+	}
+	if !strings.Contains(m2.status, "Press 'q' again") {
+		t.Errorf("expected confirmation prompt, got %q", m2.status)
+	}
+
+	_, cmd = m2.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("q")})
+	if cmd == nil {
+		t.Errorf("expected second q to quit")
+	}
+
+	//	A stale first press no longer counts
+	m2.quitPending = time.Now().Add(-3 * time.Second)
+	_, cmd = m2.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("q")})
+	if cmd != nil {
+		t.Errorf("expected q after the confirm window not to quit")
+	}
+}
+
+func TestFormatDeparturesByDirection(t *testing.T) {
+	deps := map[string][]departureInfo{
+		"Antioch":     {{Minutes: "3", Platform: "2", Direction: "North"}},
+		"Richmond":    {{Minutes: "8", Platform: "2", Direction: "North"}},
+		"SF Airport":  {{Minutes: "5", Platform: "1", Direction: "South"}},
+		"Millbrae":    {{Minutes: "11", Platform: "1", Direction: "South"}},
+		"Dublin/Plsn": {{Minutes: "6", Platform: "1", Direction: "South"}},
+	}
+	got := formatDepartures(deps, renderOptions{})
+
+	north := strings.Index(got, "Northbound (Platform 2)")
+	south := strings.Index(got, "Southbound (Platform 1)")
+	if north < 0 || south < 0 {
+		t.Fatalf("expected direction headers, got %q", got)
+	}
+	if i := strings.Index(got, "Richmond:"); i < north || i > south {
+		t.Errorf("expected Richmond under Northbound, got %q", got)
+	}
+	if i := strings.Index(got, "Millbrae:"); i < south {
+		t.Errorf("expected Millbrae under Southbound, got %q", got)
+	}
+
+	//	Without direction data the per-destination layout is kept
+	deps["Antioch"][0].Direction = ""
+	if got := formatDepartures(deps, renderOptions{}); strings.Contains(got, "bound") {
+		t.Errorf("expected no direction headers without direction data, got %q", got)
+	}
+}
+
+func TestDemoMode(t *testing.T) {
+	oldGet := httpGet
+	httpGet = demoGet
+	defer func() { httpGet = oldGet }()
+
+	msg := fetchStations("demo")()
+	stations, ok := msg.([]station)
+	if !ok || len(stations) == 0 {
+		t.Fatalf("expected demo stations, got %v", msg)
+	}
+
+	for _, st := range stations {
+		deps, err := getDepartures("demo", st.Abbr, "")
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", st.Abbr, err)
+		}
+		if len(deps) == 0 {
+			t.Errorf("expected demo departures for %s", st.Abbr)
+		}
+	}
+
+	//	Schedules too, for --at, --with-scheduled and --arrive
+	at := time.Date(2026, 10, 16, 18, 0, 0, 0, time.Local)
+	scheduled, err := getScheduledDepartures("demo", "POWL", at)
+	if err != nil || len(scheduled) == 0 {
+		t.Errorf("expected demo scheduled departures for POWL, got %v, %v", scheduled, err)
+	}
+	trips, err := getArrivals("demo", "POWL", "DUBL")
+	if err != nil || len(trips) == 0 || trips[0].Origin != "POWL" || trips[0].Destination != "DUBL" {
+		t.Errorf("expected demo trips from POWL to DUBL, got %+v, %v", trips, err)
+	}
+}
+
+// Delivers the departures fetched by a station selection, as the program would
+func loaded(t *testing.T, updated tea.Model, cmd tea.Cmd) model {
+	t.Helper()
+	cmds := []tea.Cmd{cmd}
+	for len(cmds) > 0 {
+		c := cmds[0]
+		cmds = cmds[1:]
+		if c == nil {
+			continue
+		}
+		switch msg := c().(type) {
+		case tea.BatchMsg:
+			cmds = append(cmds, msg...)
+		case departuresMsg, lockedMsg:
+			updated, _ = updated.Update(msg)
+			return updated.(model)
+		}
+	}
+	t.Fatal("expected a departures fetch")
+	return model{}
+}
+
+// Sends each rune of text as a separate keypress
+func typeText(m model, text string) model {
+	for _, r := range text {
+		updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		m = updated.(model)
+	}
+	return m
+}
+
+func TestFilterClampsCursor(t *testing.T) {
+	var stations []station
+	for i := 0; i < 50; i++ {
+		stations = append(stations, station{Name: fmt.Sprintf("Station %02d", i), Abbr: fmt.Sprintf("S%02d", i)})
+	}
+	stations = append(stations, station{Name: "Powell St.", Abbr: "POWL"}, station{Name: "Civic Center", Abbr: "CIVC"})
+
+	m := model{stations: stations, cursor: 40}
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("/")})
+	m = typeText(updated.(model), "c")
+
+	visible := m.visibleStations()
+	if len(visible) != 1 || visible[0].Abbr != "CIVC" {
+		t.Fatalf("expected only CIVC to match, got %v", visible)
+	}
+	if m.cursor != 0 {
+		t.Errorf("expected cursor clamped to 0, got %d", m.cursor)
+	}
+
+	//	Moving down can't go past the filtered list
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyDown})
+	if c := updated.(model).cursor; c != 0 {
+		t.Errorf("expected cursor to stay at 0, got %d", c)
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	var path, orig string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, orig = r.URL.Path, r.URL.Query().Get("orig")
+		w.Write([]byte(`{"root": {}}`))
+	}))
+	defer server.Close()
+
+	oldBase := baseURL
+	baseURL = server.URL + "/api"
+	defer func() { baseURL = oldBase }()
+
+	if _, err := getDepartures("fake_key", "POWL", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "/api/etd.aspx" || orig != "POWL" {
+		t.Errorf("expected request to the mock server's /api/etd.aspx, got %s (orig=%s)", path, orig)
+	}
+}
+
+func TestWalkingDistance(t *testing.T) {
+	here, err := parseLocation("37.7845, -122.4080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseLocation("37.7845"); err == nil {
+		t.Error("expected error for missing longitude")
+	}
+
+	//	Powell St to Civic Center is a little under a kilometer
+	powl := location{Lat: 37.784471, Lon: -122.407974}
+	civc := location{Lat: 37.779732, Lon: -122.414123}
+	if km := haversineKm(powl, civc); km < 0.7 || km > 0.8 {
+		t.Errorf("haversineKm = %.3f, want about 0.75", km)
+	}
+	if got := walkingMinutes(1); got != 12 {
+		t.Errorf("walkingMinutes(1) = %d, want 12", got)
+	}
+
+	m := model{near: &here}
+	line := m.distanceLine(station{Name: "Civic Center", Latitude: "37.779732", Longitude: "-122.414123"})
+	if line != "0.8 km away, about 10 min walk\n" {
+		t.Errorf("distanceLine = %q", line)
+	}
+	if m.distanceLine(station{Name: "Nowhere"}) != "" {
+		t.Error("expected no distance for a station without coordinates")
+	}
+}
+
+func TestPersistViewSettings(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	serveJSON(t, `{"root": {"station": [{"abbr": "POWL", "etd": [{
+		"destination": "Dublin", "estimate": [{"minutes": "4", "platform": "2"}]
+	}]}]}}`)
+
+	m := model{remember: true, args: []string{"powl"}, selectedName: "Powell St."}
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("d")})
+	updated, _ = updated.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("t")})
+	updated, _ = updated.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("g")})
+
+	state, err := loadState()
+	if err != nil {
+		t.Fatalf("unexpected error loading state: %v", err)
+	}
+	want := appState{LastStation: "POWL", Direction: "n", SortByTime: true, StationSort: "name"}
+	if !reflect.DeepEqual(state, want) {
+		t.Errorf("expected saved state %+v, got %+v", want, state)
+	}
+
+	//	x resets to defaults and saves that too
+	updated, _ = updated.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("x")})
+	if m2 := updated.(model); m2.direction != "" || m2.sortByTime || m2.stationSort != 0 {
+		t.Errorf("expected defaults after reset, got direction %q sortByTime %v stationSort %d", m2.direction, m2.sortByTime, m2.stationSort)
+	}
+	if state, _ := loadState(); !reflect.DeepEqual(state, appState{LastStation: "POWL"}) {
+		t.Errorf("expected reset state to be saved, got %+v", state)
+	}
+}
+
+func TestStationArgSavesLastStation(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	serveJSON(t, `{"root": {"station": [{"abbr": "POWL", "etd": []}]}}`)
+
+	m := model{remember: true, lastStation: "EMBR", args: []string{"powl"}}
+	m.Update([]station{{Name: "Embarcadero", Abbr: "EMBR"}, {Name: "Powell St.", Abbr: "POWL"}})
+
+	if state, err := loadState(); err != nil || state.LastStation != "POWL" {
+		t.Errorf("expected POWL saved as the last station, got %+v (err %v)", state, err)
+	}
+}
+
+func TestViewStationList(t *testing.T) {
+	deps := map[string][]departureInfo{
+		"Dublin/Pleasanton": {{Minutes: "4", Platform: "2"}, {Minutes: "19", Platform: "2"}},
+		"Richmond":          {{Minutes: "Leaving", Platform: "1"}},
+	}
+	m := model{
+		stations: []station{{Name: "Embarcadero", Abbr: "EMBR"}, {Name: "Powell St.", Abbr: "POWL"}},
+		cursor:   1,
+		info:     "Powell St.\n\n" + formatDepartures(deps, renderOptions{}),
+	}
+	view := m.View()
+
+	for _, want := range []string{
+		"BART Stations:",
+		"  Embarcadero, (EMBR)",
+		"> Powell St., (POWL)",
+		"Dublin/Pleasanton",
+		"in 4 min | Platform 2",
+		"Leaving now | Platform 1",
+		"Press 'q' to quit. Press 'r' to refresh",
+	} {
+		if !strings.Contains(view, want) {
+			t.Errorf("expected view to contain %q, got:\n%s", want, view)
+		}
+	}
+	if strings.Contains(view, "> Embarcadero") {
+		t.Error("cursor should only mark the selected station")
+	}
+
+	//	Before anything is selected the departures column shows a hint
+	m.info = ""
+	if view := m.View(); !strings.Contains(view, "Press Enter to see departures") {
+		t.Errorf("expected hint text, got:\n%s", view)
+	}
+}
+
+func TestFormatDeparturesNextOnly(t *testing.T) {
+	deps := map[string][]departureInfo{
+		"Antioch": {{Minutes: "27", Platform: "1"}, {Minutes: "12", Platform: "1"}},
+		"Dublin":  {{Minutes: "9", Platform: "2"}},
+	}
+	got := formatDepartures(deps, renderOptions{nextOnly: true})
+
+	if !strings.Contains(got, "in 12 min") || strings.Contains(got, "in 27 min") {
+		t.Errorf("expected only Antioch's 12 min train, got %q", got)
+	}
+	if !strings.Contains(got, "in 9 min") {
+		t.Errorf("expected Dublin's only train, got %q", got)
+	}
+}
+
+func TestEstimateNumericFields(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want estimate
+	}{
+		{"strings", `{"minutes": "4", "platform": "2"}`, estimate{Minutes: "4", Platform: "2"}},
+		{"numbers", `{"minutes": 4, "platform": 2}`, estimate{Minutes: "4", Platform: "2"}},
+		{"leaving", `{"minutes": "Leaving", "platform": 1}`, estimate{Minutes: "Leaving", Platform: "1"}},
+		{"null", `{"minutes": null, "platform": "3"}`, estimate{Platform: "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got estimate
+			if err := json.Unmarshal([]byte(tt.json), &got); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+
+	var bad estimate
+	if err := json.Unmarshal([]byte(`{"minutes": true}`), &bad); err == nil {
+		t.Error("expected error for a boolean minutes value")
+	}
+
+	//	Numeric minutes still render through the usual path
+	serveJSON(t, `{"root": {"station": [{"abbr": "POWL", "etd": [{
+		"destination": "Dublin", "estimate": [{"minutes": 7, "platform": 2}]
+	}]}]}}`)
+	deps, err := getDepartures("fake_key", "POWL", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(formatDepartures(deps, renderOptions{}), "in 7 min | Platform 2") {
+		t.Errorf("expected numeric minutes to render, got %v", deps)
+	}
+}
+
+func TestSoonestOnBoard(t *testing.T) {
+	m := model{
+		board: []string{"EMBR", "POWL", "12TH"},
+		boardDeps: map[string]map[string][]departureInfo{
+			"EMBR": {"Antioch": {{Minutes: "9", Platform: "1"}}},
+			"POWL": {"Dublin": {{Minutes: "11", Platform: "2"}, {Minutes: "3", Platform: "2"}}},
+			"12TH": {"Richmond": {{Minutes: "unknown", Platform: "1"}}},
+		},
+		stations: []station{{Name: "Powell St.", Abbr: "POWL"}},
+	}
+	names := map[string]string{"POWL": "Powell St."}
+
+	want := "Soonest: Powell St. (POWL) → Dublin in 3 min, Platform 2"
+	if got := m.soonestOnBoard(names); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if view := m.View(); !strings.Contains(view, want) {
+		t.Errorf("expected soonest line on the board, got %q", view)
+	}
+
+	//	No platform clause when the platform isn't known
+	m.boardDeps["EMBR"]["Antioch"] = []departureInfo{{Minutes: "1"}}
+	if got, want := m.soonestOnBoard(names), "Soonest: EMBR → Antioch in 1 min"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	m.boardDeps["EMBR"]["Antioch"] = []departureInfo{{Minutes: "9", Platform: "1"}}
+
+	//	Trains hidden by --min-minutes don't count
+	m.minMinutes = 5
+	if got := m.soonestOnBoard(names); !strings.Contains(got, "EMBR → Antioch in 9 min") {
+		t.Errorf("expected EMBR once short trains are hidden, got %q", got)
+	}
+
+	if got := (model{board: []string{"EMBR"}}).soonestOnBoard(names); got != "" {
+		t.Errorf("expected no soonest line before data arrives, got %q", got)
+	}
+}
+
+func TestFilterCompletion(t *testing.T) {
+	m := model{stations: []station{
+		{Name: "Powell St.", Abbr: "POWL"},
+		{Name: "Pleasant Hill", Abbr: "PHIL"},
+		{Name: "Embarcadero", Abbr: "EMBR"},
+	}}
+	m = typeText(m, "/po")
+	if got := m.completion(); got != "WL" {
+		t.Fatalf("expected completion WL, got %q", got)
+	}
+	if view := m.View(); !strings.Contains(view, "WL") {
+		t.Errorf("expected ghosted completion in the view, got %q", view)
+	}
+
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyTab})
+	m = updated.(model)
+	if m.filter != "POWL" || !m.filtering {
+		t.Errorf("expected Tab to complete the filter to POWL, got %q (filtering %v)", m.filter, m.filtering)
+	}
+	if got := m.completion(); got != "" {
+		t.Errorf("expected no completion for a full abbreviation, got %q", got)
+	}
+
+	//	Names don't complete, only abbreviations
+	m.filter = "emb"
+	if got := m.completion(); got != "R" {
+		t.Errorf("expected completion R, got %q", got)
+	}
+	m.filter = "Powell"
+	if got := m.completion(); got != "" {
+		t.Errorf("expected no completion for a name, got %q", got)
+	}
+}
+
+func TestNetworkErrorMessage(t *testing.T) {
+	//	A server that has gone away refuses the connection
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	_, err := http.Get(srv.URL)
+	if err == nil {
+		t.Fatal("expected an error from a closed server")
+	}
+	if !isNetworkError(err) {
+		t.Fatalf("expected %v to be a network error", err)
+	}
+
+	updated, cmd := model{}.Update(err)
+	m := updated.(model)
+	if !strings.Contains(m.View(), "No internet connection") {
+		t.Errorf("expected friendly offline message, got %q", m.View())
+	}
+	if cmd == nil {
+		t.Error("expected a retry to be scheduled")
+	}
+
+	//	API and key problems keep their own message and don't retry
+	if isNetworkError(errInvalidAPIKey) {
+		t.Error("invalid key should not be a network error")
+	}
+	updated, cmd = model{}.Update(errors.New("bad JSON"))
+	if m := updated.(model); !strings.Contains(m.message, "Error loading stations: bad JSON") || cmd != nil {
+		t.Errorf("expected plain error without retry, got %q", m.message)
+	}
+
+	//	Stations arriving later clear the error
+	updated, _ = m.Update([]station{{Name: "Powell St.", Abbr: "POWL"}})
+	if updated.(model).err != nil {
+		t.Error("expected stations to clear the error")
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	serveJSON(t, `{"root": {"station": [{"abbr": "POWL", "etd": [
+		{"destination": "Dublin", "estimate": [
+			{"minutes": "12", "platform": "2", "direction": "South", "color": "BLUE"},
+			{"minutes": "Leaving", "platform": "2", "direction": "South", "color": "BLUE"}
+		]},
+		{"destination": "Antioch", "estimate": [{"minutes": "4", "platform": "1", "direction": "North", "color": "YELLOW"}]}
+	]}]}}`)
+
+	var out strings.Builder
+	if err := writeCSV(&out, "fake_key", []string{"powl"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "station,destination,minutes,platform,direction,color\n" +
+		"POWL,Antioch,4,1,North,YELLOW\n" +
+		"POWL,Dublin,Leaving,2,South,BLUE\n" +
+		"POWL,Dublin,12,2,South,BLUE\n"
+	if out.String() != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, out.String())
+	}
+}
+
+func TestFilterAutoSelect(t *testing.T) {
+	serveJSON(t, `{"root": {"station": [{"abbr": "EMBR", "etd": [{
+		"destination": "Antioch", "estimate": [{"minutes": "6", "platform": "1"}]
+	}]}]}}`)
+	stations := []station{
+		{Name: "Powell St.", Abbr: "POWL"},
+		{Name: "Embarcadero", Abbr: "EMBR"},
+		{Name: "El Cerrito del Norte", Abbr: "DELN"},
+	}
+
+	//	Without --auto-select a single match waits for Enter, cursor on it
+	m := typeText(model{stations: stations, cursor: 2}, "/emb")
+	if !m.filtering || m.cursor != 0 || m.info != "" {
+		t.Fatalf("expected filter to stay open on the match, got filtering %v cursor %d", m.filtering, m.cursor)
+	}
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if m2 := loaded(t, updated, cmd); !strings.Contains(m2.info, "Antioch") {
+		t.Errorf("expected Enter to show departures, got %q", m2.info)
+	}
+
+	//	With it, departures show as soon as one station is left
+	m = typeText(model{stations: stations, autoSelect: true}, "/e")
+	if !m.filtering {
+		t.Fatal("expected two matches to keep the filter open")
+	}
+	updated, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("m")})
+	m = loaded(t, updated, cmd)
+	if m.filtering || m.viewing.Abbr != "EMBR" || !strings.Contains(m.info, "Antioch") {
+		t.Errorf("expected EMBR auto-selected, got filtering %v viewing %q", m.filtering, m.viewing.Abbr)
+	}
+}
+
+func TestSameDestinationInTwoDirections(t *testing.T) {
+	serveJSON(t, `{"root": {"station": [{"abbr": "MCAR", "etd": [
+		{"destination": "Richmond", "estimate": [{"minutes": "8", "platform": "2", "direction": "North"}]},
+		{"destination": "Richmond", "estimate": [{"minutes": "3", "platform": "1", "direction": "South"}]}
+	]}]}}`)
+	deps, err := getDepartures("fake_key", "MCAR", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	//	Block order is kept under the one destination
+	want := []departureInfo{
+		{Minutes: "8", Platform: "2", Direction: "North"},
+		{Minutes: "3", Platform: "1", Direction: "South"},
+	}
+	if !reflect.DeepEqual(deps["Richmond"], want) {
+		t.Fatalf("expected %+v, got %+v", want, deps["Richmond"])
+	}
+
+	//	Grouped by direction, each train sits under its own header
+	got := formatDepartures(deps, renderOptions{})
+	if strings.Index(got, "Northbound") > strings.Index(got, "in 8 min") || strings.Index(got, "Southbound") > strings.Index(got, "in 3 min") {
+		t.Errorf("expected trains under their direction headers, got %q", got)
+	}
+
+	//	Without headers, each line is annotated
+	got = formatDestinations(deps, renderOptions{})
+	if !strings.Contains(got, "in 8 min | Platform 2 | Northbound") || !strings.Contains(got, "in 3 min | Platform 1 | Southbound") {
+		t.Errorf("expected direction on each line, got %q", got)
+	}
+	single := map[string][]departureInfo{"Antioch": {{Minutes: "5", Platform: "1", Direction: "North"}}}
+	if got := formatDestinations(single, renderOptions{}); strings.Contains(got, "Northbound") {
+		t.Errorf("expected no annotation for a single direction, got %q", got)
+	}
+}
+
+func TestAdvisoriesView(t *testing.T) {
+	serveJSON(t, `{"root": {"bsa": {"station": "BART", "type": "DELAY",
+		"description": {"#cdata-section": "There is a 10-minute delay at Embarcadero."},
+		"posted": "Fri Oct 16 2026 07:52 AM PDT"}}}`)
+
+	updated, cmd := model{}.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("a")})
+	m := updated.(model)
+	if !m.showAdvisories || cmd == nil {
+		t.Fatal("expected a to open advisories and fetch them")
+	}
+	if view := m.View(); !strings.Contains(view, "Loading advisories") {
+		t.Errorf("expected loading text, got %q", view)
+	}
+
+	updated, _ = m.Update(cmd())
+	m = updated.(model)
+	view := m.View()
+	for _, want := range []string{"Service Advisories:", "DELAY BART (posted Fri Oct 16 2026 07:52 AM PDT)", "10-minute delay at Embarcadero"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("expected %q in advisories view, got %q", want, view)
+		}
+	}
+
+	//	Long advisories scroll within the terminal height
+	m.advisories = make([]advisory, 10)
+	for i := range m.advisories {
+		m.advisories[i].Type = fmt.Sprintf("NOTICE%d", i)
+	}
+	m.height = 10
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyDown})
+	m = updated.(model)
+	view = m.View()
+	if m.advisoryScroll != 1 || strings.Contains(view, "NOTICE0") || !strings.Contains(view, "of 19, up/down to scroll") {
+		t.Errorf("expected to scroll past the first line, got scroll %d view %q", m.advisoryScroll, view)
+	}
+
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	if updated.(model).showAdvisories {
+		t.Error("expected Esc to close advisories")
+	}
+}
+
+func TestAdvisoriesViewEmpty(t *testing.T) {
+	for _, body := range []string{`{"root": {}}`, `{"root": {"bsa": ""}}`} {
+		serveJSON(t, body)
+		updated, cmd := model{}.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("a")})
+		updated, _ = updated.Update(cmd())
+		if view := updated.(model).View(); !strings.Contains(view, "No advisories") {
+			t.Errorf("expected no advisories for %s, got %q", body, view)
+		}
+	}
+}
+
+func TestTickPausedWhileBlurred(t *testing.T) {
+	calls := 0
+	oldGet := httpGet
+	httpGet = func(url string) (*http.Response, error) {
+		calls++
+		body := `{"root": {"station": [{"abbr": "POWL", "etd": [{"destination": "Dublin", "estimate": [{"minutes": "4", "platform": "2"}]}]}]}}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}
+	defer func() { httpGet = oldGet }()
+
+	updated, _ := model{args: []string{"POWL"}}.Update(tea.BlurMsg{})
+	updated, cmd := updated.Update(tickMsg{})
+	if calls != 0 {
+		t.Errorf("expected no fetch while blurred, got %d", calls)
+	}
+	if cmd == nil {
+		t.Error("expected the tick loop to keep running while blurred")
+	}
+
+	updated, cmd = updated.Update(tea.FocusMsg{})
+	if m := loaded(t, updated, cmd); calls != 1 || !strings.Contains(m.info, "Dublin") {
+		t.Errorf("expected an immediate refresh on focus, got %d calls", calls)
+	}
+	updated, cmd = updated.Update(tickMsg{})
+	loaded(t, updated, cmd)
+	if calls != 2 {
+		t.Errorf("expected ticks to fetch again once focused, got %d calls", calls)
+	}
+}
+
+func TestNotifyWhileBlurred(t *testing.T) {
+	serveJSON(t, `{"root": {"station": [{"abbr": "POWL", "etd": [{
+		"destination": "Dublin/Pleasanton", "estimate": [{"minutes": "5", "platform": "2"}]
+	}]}]}}`)
+	var sent []string
+	oldNotify := notifyFunc
+	notifyFunc = func(title, body string) error {
+		sent = append(sent, title)
+		return nil
+	}
+	defer func() { notifyFunc = oldNotify }()
+
+	//	Alarms still fire when you've stepped away from the terminal
+	m := model{blurred: true, refresh: time.Millisecond, notify: []notifyRule{{Station: "POWL", Destination: "dublin", Minutes: 6}}}
+	_, cmd := m.Update(tickMsg{})
+	batch, ok := cmd().(tea.BatchMsg)
+	if !ok {
+		t.Fatalf("expected the notify check batched with the tick, got %T", cmd())
+	}
+	var notified bool
+	for _, c := range batch {
+		if c == nil {
+			continue
+		}
+		if _, ok := c().(notifiedMsg); ok {
+			notified = true
+		}
+	}
+	if !notified || len(sent) != 1 {
+		t.Errorf("expected a notification while blurred, got %v", sent)
+	}
+}
+
+func TestLineMarkers(t *testing.T) {
+	deps := map[string][]departureInfo{
+		"Antioch": {{Minutes: "4", Platform: "1", Color: "YELLOW", HexColor: "#ffff33"}},
+		"Dublin":  {{Minutes: "9", Platform: "2"}},
+	}
+
+	//	Without colors the tag stands in for the colored dot
+	got := formatDepartures(deps, renderOptions{})
+	if !strings.Contains(got, "[Y]    in 4 min | Platform 1") {
+		t.Errorf("expected a [Y] tag on the Antioch train, got %q", got)
+	}
+	if !strings.Contains(got, "\n   in 9 min | Platform 2") {
+		t.Errorf("expected no marker without a line color, got %q", got)
+	}
+
+	opts := renderOptions{lineColors: true}
+	if got := opts.lineMarker("YELLOW", "#ffff33"); !strings.Contains(got, "●") || strings.Contains(got, "[Y]") {
+		t.Errorf("expected a colored dot only, got %q", got)
+	}
+	opts.symbols = true
+	if got := opts.lineMarker("RED", "#ff0000"); !strings.Contains(got, "●") || !strings.HasSuffix(got, " [R]") {
+		t.Errorf("expected a dot tagged [R], got %q", got)
+	}
+	if got := opts.lineMarker("TEAL", "#008080"); !strings.HasSuffix(got, "[T]") {
+		t.Errorf("expected unknown colors to use their first letter, got %q", got)
+	}
+}
+
+func TestLineMarkerColorProfiles(t *testing.T) {
+	tests := []struct {
+		profile termenv.Profile
+		want    string
+	}{
+		{termenv.TrueColor, "\x1b[38;2;255;255;51m●"},
+		{termenv.ANSI256, "\x1b[38;5;227m●"},
+		{termenv.ANSI, "\x1b[93m●"},
+		{termenv.Ascii, "[Y]"},
+	}
+	for _, tt := range tests {
+		opts := renderOptions{lineColors: true, colorProfile: tt.profile}
+		if got := opts.lineMarker("YELLOW", "#ffff33"); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%s: expected %q, got %q", tt.profile.Name(), tt.want, got)
+		}
+	}
+
+	//	A malformed hex falls back to the tag rather than an uncolored dot
+	opts := renderOptions{lineColors: true}
+	if got := opts.lineMarker("YELLOW", "ffff33"); got != "[Y]" {
+		t.Errorf("expected [Y] for a malformed hex, got %q", got)
+	}
+}
+
+func TestAutoFollowDebounce(t *testing.T) {
+	serveJSON(t, `{"root": {"station": [{"abbr": "MONT", "etd": [{
+		"destination": "Antioch", "estimate": [{"minutes": "2", "platform": "1"}]
+	}]}]}}`)
+	m := model{
+		stations: []station{{Name: "Embarcadero", Abbr: "EMBR"}, {Name: "Montgomery St.", Abbr: "MONT"}, {Name: "Powell St.", Abbr: "POWL"}},
+		viewing:  station{Name: "Embarcadero", Abbr: "EMBR"},
+	}
+
+	//	Off by default: moving only moves the cursor
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyDown})
+	if cmd != nil {
+		t.Fatal("expected no fetch without auto-follow")
+	}
+
+	m = typeText(m, "f")
+	updated, first := m.Update(tea.KeyMsg{Type: tea.KeyDown})
+	updated, second := updated.Update(tea.KeyMsg{Type: tea.KeyDown})
+	updated, _ = updated.Update(tea.KeyMsg{Type: tea.KeyUp})
+	if first == nil || second == nil {
+		t.Fatal("expected each move to schedule a follow")
+	}
+
+	//	Only the last move's timer fetches
+	stale, _ := updated.Update(followMsg{seq: 1})
+	if stale.(model).viewing.Abbr != "EMBR" {
+		t.Errorf("expected a superseded move to be ignored, got %q", stale.(model).viewing.Abbr)
+	}
+	updated, cmd = updated.Update(followMsg{seq: updated.(model).followSeq})
+	if m2 := loaded(t, updated, cmd); m2.viewing.Abbr != "MONT" || !strings.Contains(m2.info, "Antioch") {
+		t.Errorf("expected departures for MONT, got %q (%q)", m2.viewing.Abbr, m2.info)
+	}
+}
+
+func TestStationArgByName(t *testing.T) {
+	serveJSON(t, `{"root": {"station": [{"abbr": "POWL", "etd": [{
+		"destination": "Dublin", "estimate": [{"minutes": "4", "platform": "2"}]
+	}]}]}}`)
+	stations := []station{
+		{Name: "Powell St.", Abbr: "POWL"},
+		{Name: "Civic Center/UN Plaza", Abbr: "CIVC"},
+		{Name: "16th St. Mission", Abbr: "16TH"},
+		{Name: "24th St. Mission", Abbr: "24TH"},
+	}
+
+	updated, cmd := model{args: []string{"powell st"}}.Update(stations)
+	m := loaded(t, updated, cmd)
+	if !m.locked() || m.selectedName != "Powell St." || !strings.Contains(m.info, "Dublin") {
+		t.Errorf("expected a name to lock onto POWL, got %q (%q)", m.selectedName, m.info)
+	}
+	if m.args[0] != "POWL" {
+		t.Errorf("expected the argument resolved to POWL, got %q", m.args[0])
+	}
+
+	//	More than one match shows just those stations to pick from
+	updated, _ = model{args: []string{"Mission"}}.Update(stations)
+	m = updated.(model)
+	if m.locked() || m.filter != "Mission" {
+		t.Fatalf("expected the list filtered to the matches, got args %v filter %q", m.args, m.filter)
+	}
+	if visible := m.visibleStations(); len(visible) != 2 || visible[0].Abbr != "16TH" {
+		t.Errorf("expected the two Mission stations, got %v", visible)
+	}
+}
+
+func TestStationBanner(t *testing.T) {
+	if got := (renderOptions{}).title("Powell St."); got != "Powell St." {
+		t.Errorf("expected a plain title without the banner, got %q", got)
+	}
+
+	got := (renderOptions{banner: true}).title("Powell St.")
+	lines := strings.Split(got, "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "POWELL ST.") || !strings.Contains(lines[0], "═") {
+		t.Errorf("expected the name in a bordered box, got:\n%s", got)
+	}
+}
+
+func TestTickRefreshesLockedStation(t *testing.T) {
+	fail := false
+	oldGet := httpGet
+	httpGet = func(url string) (*http.Response, error) {
+		if fail {
+			return nil, errors.New("connection reset")
+		}
+		if !strings.Contains(url, "orig=POWL") {
+			t.Errorf("expected a request for POWL, got %s", url)
+		}
+		body := `{"root": {"station": [{"abbr": "POWL", "etd": [{"destination": "Dublin/Pleasanton", "estimate": [{"minutes": "6", "platform": "2"}]}]}]}}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}
+	defer func() { httpGet = oldGet }()
+
+	m := model{args: []string{"powl"}, selectedName: "Powell St."}
+	updated, cmd := m.Update(tickMsg{})
+	m = loaded(t, updated, cmd)
+	if !strings.HasPrefix(m.info, "Powell St. Departures\n\n") || !strings.Contains(m.info, "Dublin/Pleasanton:\n   in 6 min | Platform 2") {
+		t.Errorf("expected refreshed departures, got %q", m.info)
+	}
+	if cmd == nil {
+		t.Error("expected the next tick to be scheduled")
+	}
+
+	fail = true
+	updated, cmd = model{args: []string{"powl"}}.Update(tickMsg{})
+	if got := loaded(t, updated, cmd).info; got != "Error refreshing departures for POWL: connection reset" {
+		t.Errorf("unexpected error message %q", got)
+	}
+}
+
+func TestTickKeepsStaleDepartures(t *testing.T) {
+	oldGet := httpGet
+	httpGet = func(url string) (*http.Response, error) {
+		return nil, errors.New("connection reset")
+	}
+	defer func() { httpGet = oldGet }()
+
+	m := model{
+		args:       []string{"POWL"},
+		departures: map[string][]departureInfo{"Dublin": {{Minutes: "4", Platform: "2"}}},
+		info:       "last good board",
+		lastUpdate: time.Now().Add(-30 * time.Second),
+	}
+	updated, cmd := m.Update(tickMsg{})
+	m = loaded(t, updated, cmd)
+	if m.info != "last good board" || m.departures == nil {
+		t.Errorf("expected the previous departures to stay, got %q", m.info)
+	}
+	if view := m.View(); !strings.Contains(view, "⚠ stale — last update 30s ago") {
+		t.Errorf("expected a stale indicator, got %q", view)
+	}
+
+	//	The next good refresh clears it
+	httpGet = func(url string) (*http.Response, error) {
+		body := `{"root": {"station": [{"abbr": "POWL", "etd": [{"destination": "Dublin", "estimate": [{"minutes": "3", "platform": "2"}]}]}]}}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}
+	updated, cmd = m.Update(tickMsg{})
+	if m := loaded(t, updated, cmd); m.stale || strings.Contains(m.View(), "stale") {
+		t.Error("expected a successful refresh to clear the stale indicator")
+	}
+}
+
+func TestDepartureColumns(t *testing.T) {
+	dep := departureInfo{Minutes: "5", Platform: "2", Direction: "North", Length: "10", BikeFlag: "1"}
+
+	if got := (renderOptions{}).departureLine(dep); got != "   in 5 min | Platform 2" {
+		t.Errorf("expected today's default line, got %q", got)
+	}
+
+	cols, err := parseColumns("length, Minutes,bike,direction")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "10 cars |    in 5 min | bikes OK | Northbound"
+	if got := (renderOptions{columns: cols}).departureLine(dep); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if got := (renderOptions{columns: cols, terse: true}).departureLine(dep); !strings.HasPrefix(got, "10 cars |    5 min |") {
+		t.Errorf("expected terse minutes in the chosen order, got %q", got)
+	}
+
+	if _, err := parseColumns("minutes,speed"); err == nil {
+		t.Error("expected an error for an unknown column")
+	}
+}
+
+func TestCustomKeymap(t *testing.T) {
+	km, err := newKeyMap(map[string][]string{"up": {"k"}, "down": {"j"}, "quit": {"x"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m := model{
+		stations: []station{{Name: "Embarcadero", Abbr: "EMBR"}, {Name: "Powell St.", Abbr: "POWL"}},
+		keys:     &km,
+	}
+
+	m = typeText(m, "j")
+	if m.cursor != 1 {
+		t.Errorf("expected j to move down, cursor at %d", m.cursor)
+	}
+	m = typeText(m, "k")
+	if m.cursor != 0 {
+		t.Errorf("expected k to move up, cursor at %d", m.cursor)
+	}
+	if _, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("x")}); cmd == nil {
+		t.Error("expected x to quit")
+	}
+
+	//	Unmentioned actions keep their defaults
+	if _, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")}); cmd == nil {
+		t.Error("expected r to still refresh")
+	}
+
+	//	A zero-value model uses the default keys
+	if m := typeText(model{stations: m.stations}, "s"); m.cursor != 1 {
+		t.Errorf("expected s to move down by default, cursor at %d", m.cursor)
+	}
+
+	if _, err := newKeyMap(map[string][]string{"jump": {"g"}}); err == nil {
+		t.Error("expected an error for an unknown action")
+	}
+}
+
+func TestDepartureHistogram(t *testing.T) {
+	deps := []departureInfo{
+		{Minutes: "Leaving"}, {Minutes: "4"}, {Minutes: "25"}, {Minutes: "75"}, {Minutes: "unknown"},
+	}
+	if got := departureHistogram(deps); got != "█·▄···" {
+		t.Errorf("expected █·▄···, got %q", got)
+	}
+	if got := departureHistogram(nil); got != "······" {
+		t.Errorf("expected an empty histogram, got %q", got)
+	}
+
+	out := formatDepartures(map[string][]departureInfo{"Dublin": deps[:2]}, renderOptions{histogram: true})
+	if !strings.Contains(out, "Dublin: █·····\n") {
+		t.Errorf("expected the histogram after the destination, got %q", out)
+	}
+}
+
+func TestLastTrainsNote(t *testing.T) {
+	late := time.Date(2026, 10, 16, 23, 40, 0, 0, time.Local)
+	noon := time.Date(2026, 10, 16, 12, 0, 0, 0, time.Local)
+
+	if got := lastTrainsNote(1, late); got != "(last train)" {
+		t.Errorf("expected (last train), got %q", got)
+	}
+	if got := lastTrainsNote(2, late); got != "(last trains)" {
+		t.Errorf("expected (last trains), got %q", got)
+	}
+	if got := lastTrainsNote(3, late); got != "" {
+		t.Errorf("expected no note for a full list, got %q", got)
+	}
+	if got := lastTrainsNote(1, noon); got != "" {
+		t.Errorf("expected no note during the day, got %q", got)
+	}
+
+	//	With --count the full list is that many trains, not BART's usual
+	defer func() { estimateCount = 0 }()
+	estimateCount = 2
+	if got := lastTrainsNote(2, late); got != "" {
+		t.Errorf("expected no note for a full list with --count 2, got %q", got)
+	}
+	estimateCount = 4
+	if got := lastTrainsNote(3, late); got != "(last trains)" {
+		t.Errorf("expected (last trains) for 3 of 4 with --count 4, got %q", got)
+	}
+	estimateCount = 0
+
+	oldClock := clock
+	clock = func() time.Time { return late }
+	defer func() { clock = oldClock }()
+	out := formatDepartures(map[string][]departureInfo{"Millbrae": {{Minutes: "12", Platform: "2"}}}, renderOptions{})
+	if !strings.Contains(out, "Millbrae: (last train)\n") {
+		t.Errorf("expected the destination annotated, got %q", out)
+	}
+}
+
+func TestEstimateCount(t *testing.T) {
+	var requested string
+	oldGet := httpGet
+	httpGet = func(url string) (*http.Response, error) {
+		requested = url
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"root": {"station": []}}`))}, nil
+	}
+	defer func() { httpGet = oldGet }()
+
+	getDepartures("fake_key", "POWL", "")
+	if strings.Contains(requested, "&a=") {
+		t.Errorf("expected BART's default count, got %s", requested)
+	}
+
+	estimateCount = 4
+	defer func() { estimateCount = 0 }()
+	getDepartures("fake_key", "POWL", "")
+	if !strings.HasSuffix(requested, "&a=4&b=0") {
+		t.Errorf("expected the count in the URL, got %s", requested)
+	}
+}
+
+func TestCursorWrap(t *testing.T) {
+	stations := []station{{Name: "Embarcadero", Abbr: "EMBR"}, {Name: "Montgomery St.", Abbr: "MONT"}, {Name: "Powell St.", Abbr: "POWL"}}
+
+	//	Without --wrap the cursor stops at the ends
+	if m := typeText(model{stations: stations}, "w"); m.cursor != 0 {
+		t.Errorf("expected cursor to stay at the top, got %d", m.cursor)
+	}
+
+	m := typeText(model{stations: stations, wrapCursor: true}, "w")
+	if m.cursor != 2 {
+		t.Errorf("expected up from the top to wrap to 2, got %d", m.cursor)
+	}
+	if m = typeText(m, "s"); m.cursor != 0 {
+		t.Errorf("expected down from the bottom to wrap to 0, got %d", m.cursor)
+	}
+}
+
+func TestFilterMatchCount(t *testing.T) {
+	m := model{stations: []station{
+		{Name: "16th St. Mission", Abbr: "16TH"},
+		{Name: "24th St. Mission", Abbr: "24TH"},
+		{Name: "Powell St.", Abbr: "POWL"},
+	}}
+	if view := m.View(); strings.Contains(view, "Showing") {
+		t.Errorf("expected no count without a filter, got %q", view)
+	}
+
+	m = typeText(m, "/mission")
+	if view := m.View(); !strings.Contains(view, "Showing 2 of 3 stations") {
+		t.Errorf("expected a match count, got %q", view)
+	}
+}
+
+func TestDefaultStation(t *testing.T) {
+	cfg := config{DefaultStation: "EMBR"}
+	t.Setenv("BART_DEFAULT_STATION", "")
+
+	if got := withDefaultStation(nil, config{}); len(got) != 0 {
+		t.Errorf("expected no station, got %v", got)
+	}
+	if got := withDefaultStation(nil, cfg); !reflect.DeepEqual(got, []string{"EMBR"}) {
+		t.Errorf("expected the config station, got %v", got)
+	}
+
+	t.Setenv("BART_DEFAULT_STATION", "POWL")
+	if got := withDefaultStation(nil, cfg); !reflect.DeepEqual(got, []string{"POWL"}) {
+		t.Errorf("expected the environment to win over the config, got %v", got)
+	}
+	if got := withDefaultStation([]string{"12TH"}, cfg); !reflect.DeepEqual(got, []string{"12TH"}) {
+		t.Errorf("expected an argument to win, got %v", got)
+	}
+}
+
+func TestDecodeErrorContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "<html><body>Service temporarily unavailable</body></html>")
+	}))
+	defer server.Close()
+	oldBase := baseURL
+	baseURL = server.URL
+	defer func() { baseURL = oldBase }()
+
+	_, err := getDepartures("fake_key", "POWL", "")
+	if err == nil || err.Error() != "BART API returned an unexpected (non-JSON) response (503 Service Unavailable)" {
+		t.Errorf("unexpected error for an HTML page: %v", err)
+	}
+
+	serveJSON(t, `{"root": {"station": "not a list`)
+	msg := fetchStations("fake_key")()
+	err, ok := msg.(error)
+	if !ok {
+		t.Fatalf("expected an error, got %v", msg)
+	}
+	if !strings.Contains(err.Error(), "decoding BART API response (200") || !strings.Contains(err.Error(), `"{\"root\": {\"station\": \"not a list"`) {
+		t.Errorf("expected status and body snippet in %q", err)
+	}
+}
+
+func TestRefreshCountdown(t *testing.T) {
+	m := model{info: "Powell St."}
+	if view := m.View(); strings.Contains(view, "next refresh") {
+		t.Errorf("expected no countdown before a refresh is scheduled, got %q", view)
+	}
+
+	//	Only a locked station or a board refreshes, not the station list
+	m.nextRefresh = time.Now().Add(3 * time.Second)
+	m.stations = []station{{Name: "Powell St.", Abbr: "POWL"}}
+	if view := m.View(); strings.Contains(view, "next refresh") {
+		t.Errorf("expected no countdown in the station list, got %q", view)
+	}
+	m.stations, m.args = nil, []string{"POWL"}
+	if view := m.View(); !strings.Contains(view, "next refresh in 3s") {
+		t.Errorf("expected a countdown, got %q", view)
+	}
+
+	//	Each tick schedules the next refresh
+	updated, _ := model{refresh: 10 * time.Second}.Update(tickMsg{})
+	if until := time.Until(updated.(model).nextRefresh); until < 9*time.Second || until > 10*time.Second {
+		t.Errorf("expected the next refresh in about 10s, got %v", until)
+	}
+	if _, cmd := m.Update(countdownMsg{}); cmd == nil {
+		t.Error("expected the countdown to keep running")
+	}
+}
+
+func TestTrainMapView(t *testing.T) {
+	m := model{
+		showRoutes:      true,
+		experimentalMap: true,
+		routes:          []route{{Name: "Antioch - SFIA/Millbrae", Abbr: "ANTC-MLBR", Color: "YELLOW"}},
+		stations:        []station{{Name: "Embarcadero", Abbr: "EMBR"}, {Name: "Montgomery St.", Abbr: "MONT"}, {Name: "Powell St.", Abbr: "POWL"}},
+	}
+	updated, cmd := m.Update(routeInfoMsg{stops: []string{"EMBR", "MONT", "POWL"}})
+	if cmd == nil {
+		t.Fatal("expected train positions to be fetched with the line's stations")
+	}
+	m = updated.(model)
+
+	updated, _ = m.Update(trainMapMsg{byStation: map[string]map[string][]departureInfo{
+		"EMBR": {"SF Airport": {{Minutes: "Leaving", Color: "YELLOW"}}, "Antioch": {{Minutes: "2", Color: "YELLOW"}}},
+		"MONT": {"SF Airport": {{Minutes: "8", Color: "YELLOW"}}},
+		"POWL": {"SF Airport": {{Minutes: "3", Color: "YELLOW"}}, "Richmond": {{Minutes: "1", Color: "RED"}}},
+	}})
+	view := updated.(model).View()
+
+	want := "  ● Embarcadero (EMBR)\n" +
+		"  │\n" +
+		"  ○ Montgomery St. (MONT)\n" +
+		"  ▼\n" +
+		"  ○ Powell St. (POWL)\n"
+	if !strings.Contains(view, "experimental") || !strings.Contains(view, want) {
+		t.Errorf("expected train positions:\n%s\ngot:\n%s", want, view)
+	}
+}
+
+func TestFavorites(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	m := model{stations: []station{{Name: "Embarcadero", Abbr: "EMBR"}, {Name: "Powell St.", Abbr: "POWL"}}, cursor: 1}
+	m = typeText(m, "*")
+	if !m.isFavorite("POWL") || !strings.Contains(m.View(), "Powell St., (POWL) ★") {
+		t.Errorf("expected POWL starred in the list, got %v", m.favorites)
+	}
+
+	//	Saved even with --no-restore (remember off)
+	state, err := loadState()
+	if err != nil || !reflect.DeepEqual(state.Favorites, []string{"POWL"}) {
+		t.Fatalf("expected favorites saved, got %+v (err %v)", state, err)
+	}
+
+	m = typeText(m, "*")
+	if m.isFavorite("POWL") {
+		t.Error("expected a second * to unstar POWL")
+	}
+	if state, _ := loadState(); len(state.Favorites) != 0 {
+		t.Errorf("expected favorites cleared, got %v", state.Favorites)
+	}
+}
+
+func TestFavoriteNumberKeys(t *testing.T) {
+	serveJSON(t, `{"root": {"station": [{"abbr": "EMBR", "etd": [{
+		"destination": "Antioch", "estimate": [{"minutes": "3", "platform": "2"}]
+	}]}]}}`)
+
+	m := model{
+		stations:  []station{{Name: "Embarcadero", Abbr: "EMBR"}, {Name: "Montgomery St.", Abbr: "MONT"}, {Name: "Powell St.", Abbr: "POWL"}},
+		favorites: []string{"MONT", "EMBR"},
+		cursor:    2,
+	}
+	view := m.View()
+	if !strings.Contains(view, "(MONT) ★1") || !strings.Contains(view, "(EMBR) ★2") {
+		t.Errorf("expected favorites numbered in the list, got:\n%s", view)
+	}
+
+	//	A digit jumps to its favorite from any view
+	m.showRoutes = true
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("2")})
+	m = loaded(t, updated, cmd)
+	if m.showRoutes || m.cursor != 0 || !strings.Contains(m.info, "Antioch") {
+		t.Errorf("expected EMBR departures with the cursor on it, got cursor %d, info %q", m.cursor, m.info)
+	}
+
+	m = typeText(m, "3")
+	if !strings.Contains(m.status, "No favorite 3") {
+		t.Errorf("expected a note about the missing favorite, got %q", m.status)
+	}
+
+	//	In the locked view the favorite replaces the station
+	m = model{args: []string{"POWL"}, favorites: []string{"EMBR"}}
+	updated, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("1")})
+	m = loaded(t, updated, cmd)
+	if m.args[0] != "EMBR" || !strings.Contains(m.info, "EMBR Departures") {
+		t.Errorf("expected the locked view to switch to EMBR, got args %v, info %q", m.args, m.info)
+	}
+}
+
+func TestFormatDeparturesHighlightsNext(t *testing.T) {
+	mark := lipgloss.NewStyle().Transform(func(s string) string { return "*" + s })
+	deps := map[string][]departureInfo{
+		"Dublin": {{Minutes: "12", Platform: "2"}, {Minutes: "4", Platform: "2"}, {Minutes: "27", Platform: "2"}},
+	}
+	got := formatDepartures(deps, renderOptions{theme: theme{Next: mark}})
+
+	if !strings.Contains(got, "*   in 4 min | Platform 2") {
+		t.Errorf("expected the 4 min train highlighted, got %q", got)
+	}
+	if strings.Count(got, "*") != 1 {
+		t.Errorf("expected exactly one highlighted train, got %q", got)
+	}
+}
+
+func TestQuitOnSignals(t *testing.T) {
+	quit := make(chan struct{})
+	stop := quitOnSignals(func() { close(quit) })
+	defer stop()
+
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(syscall.SIGHUP); err != nil {
+		t.Skipf("cannot signal self: %v", err)
+	}
+	select {
+	case <-quit:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected SIGHUP to quit the program")
+	}
+}
+
+func TestGetScheduledDepartures(t *testing.T) {
+	serveJSON(t, `{"root": {"station": {"abbr": "POWL", "item": [
+		{"@line": "ROUTE 12", "@trainHeadStation": "DUBL", "@origTime": "5:55 PM", "@bikeflag": "1"},
+		{"@line": "ROUTE 12", "@trainHeadStation": "DUBL", "@origTime": "6:04 PM", "@bikeflag": "1"},
+		{"@line": "ROUTE 1", "@trainHeadStation": "SFIA", "@origTime": "6:30 PM", "@bikeflag": "1"},
+		{"@line": "ROUTE 12", "@trainHeadStation": "DUBL", "@origTime": "7:04 PM", "@bikeflag": "1"}
+	]}}}`)
+
+	at, err := parseAtTime("18:00", clock())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deps, err := getScheduledDepartures("fake_key", "POWL", at)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	//	Only trains in the hour after 6:00 PM
+	want := map[string][]departureInfo{
+		"DUBL": {{Minutes: "4", Time: "6:04 PM", BikeFlag: "1", Dest: "DUBL"}},
+		"SFIA": {{Minutes: "30", Time: "6:30 PM", BikeFlag: "1", Dest: "SFIA"}},
+	}
+	if !reflect.DeepEqual(deps, want) {
+		t.Errorf("expected %+v, got %+v", want, deps)
+	}
+	if got := formatDestinations(deps, renderOptions{}); !strings.Contains(got, "at 6:04 PM (scheduled)\n") {
+		t.Errorf("expected scheduled times shown, got %q", got)
+	}
+
+	//	A time already passed today means tomorrow
+	if next, _ := parseAtTime("9:30am", clock()); next.Day() != clock().Day()+1 || next.Hour() != 9 {
+		t.Errorf("expected 9:30am tomorrow, got %v", next)
+	}
+	if _, err := parseAtTime("soon", clock()); err == nil {
+		t.Error("expected an error for an invalid time")
+	}
+}
+
+func TestFirstTrainAfterService(t *testing.T) {
+	liveTrains := false
+	oldGet := httpGet
+	t.Cleanup(func() { httpGet = oldGet })
+	httpGet = func(url string) (*http.Response, error) {
+		body := `{"root": {"station": [{"abbr": "POWL", "etd": []}]}}`
+		switch {
+		case strings.Contains(url, "stnsched"):
+			body = `{"root": {"station": {"abbr": "POWL", "item": [
+				{"@line": "ROUTE 12", "@trainHeadStation": "DUBL", "@origTime": "5:13 AM", "@bikeflag": "1"},
+				{"@line": "ROUTE 1", "@trainHeadStation": "SFIA", "@origTime": "4:58 AM", "@bikeflag": "1"},
+				{"@line": "ROUTE 12", "@trainHeadStation": "DUBL", "@origTime": "12:45 AM", "@bikeflag": "1"}
+			]}}}`
+		case liveTrains:
+			body = `{"root": {"station": [{"abbr": "POWL", "etd": [{"destination": "Dublin", "estimate": [{"minutes": "4", "platform": "2", "direction": "South"}]}]}]}}`
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}
+
+	//	Late at night the first train is tomorrow morning's, not the 12:45 AM
+	//	that ends tonight's service
+	night := time.Date(2026, 10, 16, 23, 30, 0, 0, time.Local)
+	first, err := firstScheduledTrain("fake_key", "POWL", night)
+	if want := time.Date(2026, 10, 17, 4, 58, 0, 0, time.Local); err != nil || !first.Equal(want) {
+		t.Errorf("expected %v, got %v (err %v)", want, first, err)
+	}
+
+	//	No lookup during service hours
+	powl := station{Name: "Powell St.", Abbr: "POWL"}
+	if _, cmd := (model{loading: powl}).Update(departuresMsg{station: powl}); cmd != nil {
+		t.Error("expected no first train lookup at midday")
+	}
+
+	oldClock := clock
+	clock = func() time.Time { return night }
+	t.Cleanup(func() { clock = oldClock })
+
+	//	An empty board at night notes the first train once it's looked up
+	updated, cmd := model{loading: powl}.Update(departuresMsg{station: powl})
+	updated, _ = updated.Update(cmd())
+	if info := updated.(model).info; !strings.Contains(info, "Service ended — first train at 4:58 AM") {
+		t.Errorf("expected the end of service noted, got %q", info)
+	}
+
+	//	Trains still running the other way mean service hasn't ended
+	liveTrains = true
+	updated, cmd = model{loading: powl, direction: "n"}.Update(departuresMsg{station: powl})
+	if msg := cmd().(firstTrainMsg); !msg.first.IsZero() {
+		t.Errorf("expected no first train while the live feed has trains, got %v", msg.first)
+	}
+
+	//	The fixed two-direction layout keeps its headers
+	got := formatDepartures(nil, renderOptions{allDirections: true, firstTrain: first})
+	if !strings.HasPrefix(got, "Service ended — first train at 4:58 AM") || strings.Count(got, "No service this direction") != 2 {
+		t.Errorf("expected the note above both directions, got %q", got)
+	}
+}
+
+func TestLeaveExitCode(t *testing.T) {
+	deps := map[string][]departureInfo{
+		"Dublin/Pleasanton": {{Minutes: "12"}, {Minutes: "27"}},
+		"SF Airport":        {{Minutes: "Leaving"}, {Minutes: "4"}},
+	}
+
+	tests := []struct {
+		to     string
+		within int
+		want   int
+	}{
+		{"", 5, exitTrainSoon},
+		{"dublin", 10, exitNoTrainSoon},
+		{"dublin", 12, exitTrainSoon},
+		{"airport", 0, exitTrainSoon},
+		{"richmond", 60, exitNoTrainSoon},
+	}
+	for _, tt := range tests {
+		if got := leaveExitCode(deps, tt.to, tt.within); got != tt.want {
+			t.Errorf("leaveExitCode(%q, %d) = %d, want %d", tt.to, tt.within, got, tt.want)
+		}
+	}
+}
+
+func TestViewPlainCursor(t *testing.T) {
+	m := model{
+		width:       60,
+		stations:    []station{{Name: "Embarcadero", Abbr: "EMBR"}, {Name: "Powell St.", Abbr: "POWL"}},
+		cursor:      1,
+		plainCursor: true,
+	}
+	view := m.View()
+
+	if strings.Contains(view, ">") {
+		t.Errorf("expected no \">\" marker, got %q", view)
+	}
+	if !strings.Contains(view, "\n    Powell St., (POWL)\n") || !strings.Contains(view, "\n  Embarcadero, (EMBR)\n") {
+		t.Errorf("expected the selected station indented, got %q", view)
+	}
+}
+
+func TestStationArgLocksBoard(t *testing.T) {
+	var requested string
+	oldGet := httpGet
+	t.Cleanup(func() { httpGet = oldGet })
+	httpGet = func(url string) (*http.Response, error) {
+		requested = url
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"root": {"station": [{"abbr": "POWL", "etd": [
+			{"destination": "Dublin/Pleasanton", "estimate": [{"minutes": "4", "platform": "2"}, {"minutes": "19", "platform": "2"}]},
+			{"destination": "Richmond", "estimate": [{"minutes": "Leaving", "platform": "1"}]}
+		]}]}}`))}, nil
+	}
+	stations := []station{{Name: "Embarcadero", Abbr: "EMBR"}, {Name: "Powell St.", Abbr: "POWL"}}
+
+	updated, cmd := model{args: []string{"powl"}}.Update(stations)
+	m := loaded(t, updated, cmd)
+	if m.stations != nil {
+		t.Errorf("expected the station list cleared, got %v", m.stations)
+	}
+	if m.selectedName != "Powell St." {
+		t.Errorf("expected Powell St. selected, got %q", m.selectedName)
+	}
+	if !strings.Contains(requested, "orig=POWL") {
+		t.Errorf("expected departures requested for POWL, got %q", requested)
+	}
+	for _, want := range []string{"Powell St. Departures", "Dublin/Pleasanton:", "in 4 min | Platform 2", "in 19 min | Platform 2", "Richmond:", "Leaving now | Platform 1"} {
+		if !strings.Contains(m.info, want) {
+			t.Errorf("expected info to contain %q, got %q", want, m.info)
+		}
+	}
+
+	//	No station matches: nothing is fetched and the list stays up
+	requested = ""
+	updated, _ = model{args: []string{"nowhere"}}.Update(stations)
+	m = updated.(model)
+	if m.locked() || m.selectedName != "" || len(m.stations) != 2 || requested != "" {
+		t.Errorf("expected no board for an unknown station, got %q (%d stations, requested %q)", m.selectedName, len(m.stations), requested)
+	}
+}
+
+func TestMergeScheduled(t *testing.T) {
+	live := map[string][]departureInfo{
+		"Dublin/Pleasanton": {
+			{Minutes: "4", Platform: "2", Direction: "North", Dest: "DUBL"},
+			{Minutes: "19", Platform: "2", Direction: "North", Dest: "DUBL"},
+		},
+	}
+	scheduled := map[string][]departureInfo{
+		"DUBL": {
+			{Minutes: "18", Time: "12:18 PM", Dest: "DUBL"},
+			{Minutes: "20", Time: "12:20 PM", Dest: "DUBL"},
+			{Minutes: "34", Time: "12:34 PM", Dest: "DUBL"},
+		},
+		"SFIA": {{Minutes: "10", Time: "12:10 PM", Dest: "SFIA"}},
+	}
+	merged := mergeScheduled(live, scheduled, nil)
+
+	//	Trains at or just after the last live one are the same trains
+	dublin := merged["Dublin/Pleasanton"]
+	if len(dublin) != 3 || dublin[2].Time != "12:34 PM" {
+		t.Fatalf("expected one scheduled train after the live ones, got %+v", dublin)
+	}
+	if dublin[2].Platform != "2" || dublin[2].Direction != "North" {
+		t.Errorf("expected the scheduled train on the live platform, got %+v", dublin[2])
+	}
+	if len(live["Dublin/Pleasanton"]) != 2 {
+		t.Errorf("expected the live departures left untouched, got %+v", live)
+	}
+	if len(merged["SFIA"]) != 1 {
+		t.Errorf("expected a destination with no live trains kept, got %+v", merged)
+	}
+
+	got := formatDestinations(merged, renderOptions{})
+	if !strings.Contains(got, "at 12:34 PM (scheduled) | Platform 2") {
+		t.Errorf("expected the scheduled train labeled, got %q", got)
+	}
+
+	//	Destinations with only scheduled trains are named like live ones
+	merged = mergeScheduled(live, scheduled, map[string]string{"SFIA": "San Francisco International Airport"})
+	if len(merged["San Francisco International Airport"]) != 1 || merged["SFIA"] != nil {
+		t.Errorf("expected SFIA shown by name, got %+v", merged)
+	}
+}
+
+func TestScheduleCache(t *testing.T) {
+	schedules := 0
+	oldGet := httpGet
+	t.Cleanup(func() { httpGet = oldGet })
+	httpGet = func(url string) (*http.Response, error) {
+		body := `{"root": {"station": [{"abbr": "POWL", "etd": []}]}}`
+		if strings.Contains(url, "stnsched") {
+			schedules++
+			body = `{"root": {"station": {"abbr": "POWL", "item": [
+				{"@line": "ROUTE 12", "@trainHeadStation": "DUBL", "@origTime": "12:20 PM", "@bikeflag": "1"}
+			]}}}`
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}
+
+	//	Each refresh reuses the day's schedule rather than fetching it again
+	m := initialModel("fake_key", nil)
+	m.withScheduled = true
+	m.stationNames = map[string]string{"DUBL": "Dublin/Pleasanton"}
+	for range 3 {
+		deps, err := m.departuresFor("POWL")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(deps["Dublin/Pleasanton"]) != 1 {
+			t.Fatalf("expected the scheduled Dublin train by name, got %+v", deps)
+		}
+	}
+	if schedules != 1 {
+		t.Errorf("expected the schedule fetched once, got %d", schedules)
+	}
+
+	//	A new service day needs its own schedule
+	oldClock := clock
+	t.Cleanup(func() { clock = oldClock })
+	clock = func() time.Time { return oldClock().AddDate(0, 0, 1) }
+	m.departuresFor("POWL")
+	if schedules != 2 {
+		t.Errorf("expected the next day's schedule fetched, got %d fetches", schedules)
+	}
+}
+
+func TestSelectStationLoading(t *testing.T) {
+	serveJSON(t, `{"root": {"station": [{"abbr": "POWL", "etd": [{
+		"destination": "Dublin", "estimate": [{"minutes": "4", "platform": "2"}]
+	}]}]}}`)
+	m := initialModel("fake_key", nil)
+	m.stations = []station{{Name: "Embarcadero", Abbr: "EMBR"}, {Name: "Powell St.", Abbr: "POWL"}}
+	m.cursor = 1
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = updated.(model)
+	if m.loading.Abbr != "POWL" || !strings.Contains(m.View(), "Loading departures for Powell St...") || strings.Contains(m.View(), "St....") {
+		t.Fatalf("expected a loading placeholder for POWL, got %q", m.View())
+	}
+
+	//	The list stays interactive, and picking another station supersedes the fetch
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyUp})
+	updated, _ = updated.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	stale := loaded(t, updated, cmd)
+	if stale.loading.Abbr != "EMBR" || stale.viewing.Abbr != "" {
+		t.Errorf("expected the POWL response ignored while EMBR loads, got loading %q viewing %q", stale.loading.Abbr, stale.viewing.Abbr)
+	}
+
+	m = loaded(t, m, cmd)
+	if m.loading.Abbr != "" || m.viewing.Abbr != "POWL" || !strings.Contains(m.info, "Dublin") {
+		t.Errorf("expected POWL departures shown, got %q", m.info)
+	}
+}
+
+func TestCustomMinuteLabels(t *testing.T) {
+	var cfg config
+	if err := json.Unmarshal([]byte(`{"leaving_label": "Now", "minute_suffix": "m"}`), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	opts := renderOptions{leaving: cfg.LeavingLabel, minuteSuffix: cfg.MinuteSuffix}
+	deps := map[string][]departureInfo{"Dublin": {{Minutes: "Leaving", Platform: "1"}, {Minutes: "5", Platform: "1"}}}
+
+	got := formatDepartures(deps, opts)
+	if !strings.Contains(got, "        Now | Platform 1") || !strings.Contains(got, "    in 5 m | Platform 1") {
+		t.Errorf("expected the configured labels, got %q", got)
+	}
+
+	opts.terse = true
+	got = formatDepartures(deps, opts)
+	if !strings.Contains(got, "     Now | Platform 1") || !strings.Contains(got, "     5 m | Platform 1") {
+		t.Errorf("expected the configured labels with --terse, got %q", got)
+	}
+}
+
+func TestUpdateOpenMap(t *testing.T) {
+	var opened string
+	var openErr error
+	oldOpen := openURLFunc
+	openURLFunc = func(link string) error {
+		opened = link
+		return openErr
+	}
+	defer func() { openURLFunc = oldOpen }()
+
+	m := model{cursor: 1, stations: []station{
+		{Name: "Embarcadero", Abbr: "EMBR"},
+		{Name: "Powell St.", Abbr: "POWL", Latitude: "37.784471", Longitude: "-122.407974"},
+	}}
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("o")})
+	if opened != "https://www.google.com/maps/search/?api=1&query=37.784471%2C-122.407974" {
+		t.Errorf("expected POWL's coordinates opened, got %q", opened)
+	}
+	if status := updated.(model).status; status != "Opened Powell St. in your browser" {
+		t.Errorf("expected confirmation in status, got %q", status)
+	}
+
+	//	Without coordinates, search by name; a missing browser is reported
+	openErr = errors.New("no browser available (no display)")
+	m.cursor = 0
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("o")})
+	if !strings.HasSuffix(opened, "query=Embarcadero+BART+station") {
+		t.Errorf("expected a search by name, got %q", opened)
+	}
+	if status := updated.(model).status; !strings.Contains(status, "no display") {
+		t.Errorf("expected the error in status, got %q", status)
+	}
+}
+
+func TestPartialStationData(t *testing.T) {
+	serveJSON(t, `{"root": {"stations": {"station": [
+		{"name": "Embarcadero", "abbr": "EMBR"},
+		{"name": "Mystery Station", "abbr": ""},
+		{"name": "", "abbr": "POWL"}
+	]}}}`)
+
+	stations, ok := fetchStations("fake_key")().([]station)
+	if !ok {
+		t.Fatal("expected a station list")
+	}
+	want := []station{{Name: "Embarcadero", Abbr: "EMBR"}, {Name: "POWL", Abbr: "POWL"}}
+	if !reflect.DeepEqual(stations, want) {
+		t.Errorf("expected %+v, got %+v", want, stations)
+	}
+
+	//	Arg matching never lands on a station without an abbreviation
+	raw := []station{{Name: "Mystery Station", Abbr: ""}, {Name: "Embarcadero", Abbr: "EMBR"}}
+	if got := matchStations(raw, "mystery"); len(got) != 0 {
+		t.Errorf("expected no match for a station without an abbreviation, got %+v", got)
+	}
+	if got := matchStations(raw, " "); len(got) != 0 {
+		t.Errorf("expected no match for an empty argument, got %+v", got)
+	}
+}
+
+func TestOnceQuitsAfterShowing(t *testing.T) {
+	requests := 0
+	oldGet := httpGet
+	t.Cleanup(func() { httpGet = oldGet })
+	httpGet = func(url string) (*http.Response, error) {
+		requests++
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"root": {"station": [{"abbr": "POWL", "etd": [{
+			"destination": "Dublin", "estimate": [{"minutes": "4", "platform": "2"}]
+		}]}]}}`))}, nil
+	}
+
+	updated, cmd := model{args: []string{"POWL"}, once: time.Millisecond}.Update([]station{{Name: "Powell St.", Abbr: "POWL"}})
+	updated, cmd = updated.Update(cmd())
+	m := updated.(model)
+	if !strings.Contains(m.info, "Dublin") || cmd == nil {
+		t.Fatalf("expected departures and a quit timer, got %q", m.info)
+	}
+	if _, ok := cmd().(tea.QuitMsg); !ok {
+		t.Error("expected the timer to quit")
+	}
+
+	//	No refresh loop, and any key exits
+	if _, cmd := m.Update(tickMsg{}); cmd != nil || requests != 1 {
+		t.Errorf("expected ticks ignored, got %d requests", requests)
+	}
+	_, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	if cmd == nil {
+		t.Fatal("expected a key to quit")
+	}
+	if _, ok := cmd().(tea.QuitMsg); !ok {
+		t.Error("expected a key to quit")
+	}
+}
+
+func TestRedactKey(t *testing.T) {
+	tests := map[string]string{
+		"https://api.bart.gov/api/etd.aspx?cmd=etd&orig=POWL&key=SECRET&json=y": "https://api.bart.gov/api/etd.aspx?cmd=etd&orig=POWL&key=****&json=y",
+		"https://api.bart.gov/api/bsa.aspx?key=SECRET":                          "https://api.bart.gov/api/bsa.aspx?key=****",
+		"https://api.bart.gov/api/stn.aspx?cmd=stns":                            "https://api.bart.gov/api/stn.aspx?cmd=stns",
+	}
+	for in, want := range tests {
+		if got := redactKey(in); got != want {
+			t.Errorf("redactKey(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	//	Errors from the getter quote the URL without the key
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+	_, err := redactErrors(http.Get)(server.URL + "/etd.aspx?orig=POWL&key=SECRET&json=y")
+	if err == nil || strings.Contains(err.Error(), "SECRET") || !strings.Contains(err.Error(), "key=****") {
+		t.Errorf("expected the key redacted from the error, got %v", err)
+	}
+}
+
+func TestFooterFollowsMode(t *testing.T) {
+	stations := []station{{Name: "Powell St.", Abbr: "POWL"}}
+	tests := []struct {
+		name string
+		m    model
+		want string
+	}{
+		{"list", model{stations: stations}, "Press 'q' to quit. Press 'r' to refresh"},
+		{"filtering", model{stations: stations, filtering: true, filter: "po"}, "Press Esc to clear, Tab to complete, Enter to select"},
+		{"filtered", model{stations: stations, filter: "po"}, "Press Enter to select. Press Esc to clear the search."},
+		{"locked", model{args: []string{"POWL"}}, "Press Esc for the station list."},
+		{"once", model{args: []string{"POWL"}, once: time.Second}, "Press any key to exit"},
+		{"board", model{board: []string{"POWL"}}, "Press Esc for the station list. Press 'q' to quit."},
+		{"advisories", model{showAdvisories: true}, "Press 'a' to go back. Press 'q' to quit."},
+		{"routes", model{showRoutes: true}, "Press Enter to see a line's stations. Press 'l' to go back. Press 'q' to quit."},
+		{"error", model{err: errors.New("offline")}, "Press 'q' to quit."},
+	}
+	for _, tt := range tests {
+		if got := tt.m.footer(); !strings.Contains(got, tt.want) {
+			t.Errorf("%s: expected footer to contain %q, got %q", tt.name, tt.want, got)
+		}
+	}
+
+	//	Keys rebound in the config show in the hints
+	km, err := newKeyMap(map[string][]string{"quit": {"x"}, "refresh": {"ctrl+r"}, "enter": {"o"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := model{stations: stations, filter: "po", keys: &km}
+	if got, want := m.footer(), "Press 'o' to select. Press Esc to clear the search. Press 'x' to quit. Press Ctrl+r to refresh"; !strings.Contains(got, want) {
+		t.Errorf("expected footer to contain %q, got %q", want, got)
+	}
+	for _, m := range []model{{board: []string{"POWL"}}, {showAdvisories: true}, {showRoutes: true}, {err: errors.New("offline")}} {
+		m.keys = &km
+		if got := m.View(); !strings.Contains(got, "Press 'x' to quit.") || strings.Contains(got, "'q'") {
+			t.Errorf("expected the rebound quit key, got %q", got)
+		}
+	}
+	m = model{confirmQuit: true, keys: &km}
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("x")})
+	if got := updated.(model).status; got != "Press 'x' again to quit" {
+		t.Errorf("expected the rebound quit key in the confirm prompt, got %q", got)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	var times []time.Time
+	get := throttle(func(string) (*http.Response, error) {
+		times = append(times, time.Now())
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}, rate.NewLimiter(rate.Every(20*time.Millisecond), 1))
+
+	for i := 0; i < 3; i++ {
+		if _, err := get("https://api.bart.gov/api/etd.aspx"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if gap := times[2].Sub(times[0]); gap < 30*time.Millisecond {
+		t.Errorf("expected requests spaced out by the limit, got %v for 3 requests", gap)
+	}
+}
+
+func TestCycleStationSort(t *testing.T) {
+	m := model{
+		width: 60,
+		stations: []station{
+			{Name: "Powell St.", Abbr: "POWL", City: "San Francisco"},
+			{Name: "Colma", Abbr: "COLM", City: "Colma"},
+			{Name: "Coliseum", Abbr: "COLS", City: "Oakland"},
+			{Name: "Civic Center/UN Plaza", Abbr: "CIVC", City: "San Francisco"},
+		},
+	}
+	abbrs := func(m model) string {
+		var out []string
+		for _, st := range m.visibleStations() {
+			out = append(out, st.Abbr)
+		}
+		return strings.Join(out, ",")
+	}
+
+	for i, want := range []string{
+		"CIVC,COLS,COLM,POWL", //	name
+		"CIVC,COLM,COLS,POWL", //	abbreviation
+		"COLM,COLS,CIVC,POWL", //	city
+		"POWL,COLM,COLS,CIVC", //	back to as listed
+	} {
+		m = typeText(m, "g")
+		if got := abbrs(m); got != want {
+			t.Errorf("step %d: expected order %s, got %s", i, want, got)
+		}
+		if m.visibleStations()[m.cursor].Abbr != "POWL" {
+			t.Errorf("step %d: expected the cursor to stay on POWL, got %d", i, m.cursor)
+		}
+		if want == "COLM,COLS,CIVC,POWL" && !strings.Contains(m.View(), "Oakland\n  Coliseum, (COLS)") {
+			t.Errorf("expected city headings, got %q", m.View())
+		}
+	}
+}
+
+func TestMaxPerDest(t *testing.T) {
+	deps := map[string][]departureInfo{
+		"Dublin":   {{Minutes: "4", Platform: "2"}, {Minutes: "19", Platform: "2"}, {Minutes: "34", Platform: "2"}, {Minutes: "49", Platform: "2"}},
+		"Richmond": {{Minutes: "7", Platform: "1"}},
+	}
+
+	got := formatDestinations(deps, renderOptions{maxPerDest: 2})
+	want := "Dublin:\n   in 4 min | Platform 2\n  in 19 min | Platform 2\n    +2 more\n\n" +
+		"Richmond:\n   in 7 min | Platform 1\n\n"
+	if got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	if got := formatDestinations(deps, renderOptions{}); strings.Contains(got, "more") {
+		t.Errorf("expected every train without a limit, got %q", got)
+	}
+}
+
+func TestHTTPClientUsesProxyFromEnvironment(t *testing.T) {
+	client := newHTTPClient()
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("expected an *http.Transport, got %T", client.Transport)
+	}
+	if transport.Proxy == nil || reflect.ValueOf(transport.Proxy).Pointer() != reflect.ValueOf(http.ProxyFromEnvironment).Pointer() {
+		t.Error("expected the transport to use http.ProxyFromEnvironment")
+	}
+	if client.Timeout != requestTimeout {
+		t.Errorf("expected a %v timeout, got %v", requestTimeout, client.Timeout)
+	}
+}
+
+func TestStartupView(t *testing.T) {
+	m := initialModel("fake_key", nil)
+	view := m.View()
+	for _, want := range []string{"BART Schedule", "Fetching BART stations — press q to cancel", m.spinner.View()} {
+		if !strings.Contains(view, want) {
+			t.Errorf("expected startup view to contain %q, got %q", want, view)
+		}
+	}
+
+	updated, _ := m.Update([]station{{Name: "Powell St.", Abbr: "POWL"}})
+	if view := updated.(model).View(); strings.Contains(view, "Fetching BART stations") {
+		t.Errorf("expected the station list once loaded, got %q", view)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	serveJSON(t, `{"root": {"station": [{"abbr": "POWL", "etd": [{
+		"destination": "Dublin/Pleasanton", "abbreviation": "DUBL", "estimate": [
+			{"minutes": "19", "platform": "2", "direction": "North", "color": "BLUE", "hexcolor": "#0099cc", "length": "10", "bikeflag": "1", "delay": "0"},
+			{"minutes": "4", "platform": "2", "direction": "North", "color": "BLUE", "hexcolor": "#0099cc", "length": 8, "bikeflag": "1", "delay": "120"}
+		]
+	}]}]}}`)
+
+	var out strings.Builder
+	if err := writeJSON(&out, "fake_key", []string{"powl"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := `{"station":"POWL","updated":"` + clock().UTC().Format(time.RFC3339) + `","departures":{"Dublin/Pleasanton":[` +
+		`{"minutes":"4","platform":"2","direction":"North","color":"BLUE","hex_color":"#0099cc","length":"8","bike_flag":"1","delay":"120","dest_abbr":"DUBL"},` +
+		`{"minutes":"19","platform":"2","direction":"North","color":"BLUE","hex_color":"#0099cc","length":"10","bike_flag":"1","delay":"0","dest_abbr":"DUBL"}]}}` + "\n"
+	if out.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, out.String())
+	}
+}
+
+func TestAllDirections(t *testing.T) {
+	deps := map[string][]departureInfo{
+		"Richmond": {{Minutes: "7", Platform: "1", Direction: "North"}},
+	}
+
+	got := formatDepartures(deps, renderOptions{allDirections: true})
+	if !strings.Contains(got, "Northbound (Platform 1)\n\nRichmond:") || !strings.Contains(got, "Southbound\n\nNo service this direction") {
+		t.Errorf("expected both directions, got %q", got)
+	}
+	if got := formatDepartures(deps, renderOptions{}); strings.Contains(got, "Southbound") {
+		t.Errorf("expected only directions with trains by default, got %q", got)
+	}
+
+	//	Even with no trains at all
+	got = formatDepartures(map[string][]departureInfo{}, renderOptions{allDirections: true})
+	if strings.Count(got, "No service this direction") != 2 {
+		t.Errorf("expected both directions without service, got %q", got)
+	}
+}
+
+func TestGetDeparturesRecordedResponse(t *testing.T) {
+	fixture, err := os.ReadFile("testdata/etd_powl.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveJSON(t, string(fixture))
+
+	deps, err := getDepartures("fake_key", "POWL", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[string][]departureInfo{
+		"Antioch": {
+			{Minutes: "Leaving", Platform: "2", Direction: "North", Color: "YELLOW", HexColor: "#ffff33", Length: "10", BikeFlag: "1", Delay: "0", Dest: "ANTC"},
+			{Minutes: "14", Platform: "2", Direction: "North", Color: "YELLOW", HexColor: "#ffff33", Length: "8", BikeFlag: "1", Delay: "163", Dest: "ANTC"},
+		},
+		"Dublin/Pleasanton": {
+			{Minutes: "4", Platform: "2", Direction: "North", Color: "BLUE", HexColor: "#0099cc", Length: "9", BikeFlag: "1", Delay: "0", Dest: "DUBL"},
+		},
+		"Daly City": {
+			{Minutes: "7", Platform: "1", Direction: "South", Color: "GREEN", HexColor: "#339933", Length: "6", BikeFlag: "1", Delay: "0", Dest: "DALY"},
+			{Minutes: "22", Platform: "1", Direction: "South", Color: "BLUE", HexColor: "#0099cc", Length: "6", BikeFlag: "0", Delay: "0", Dest: "DALY"},
+		},
+		//	A destination with one train has a bare object, not a list
+		"SF Airport": {
+			{Minutes: "11", Platform: "1", Direction: "South", Color: "YELLOW", HexColor: "#ffff33", Length: "10", BikeFlag: "1", Delay: "0", Dest: "SFIA"},
+		},
+	}
+	if !reflect.DeepEqual(deps, want) {
+		t.Errorf("expected\n%+v\ngot\n%+v", want, deps)
+	}
+}
+
+func TestWalkHighlightsCatchableTrains(t *testing.T) {
+	tag := func(tag string) lipgloss.Style {
+		return lipgloss.NewStyle().Transform(func(s string) string { return tag + s })
+	}
+	opts := renderOptions{walk: 6, theme: theme{Next: tag("next:"), Catchable: tag("ok:"), Missed: tag("miss:")}}
+	deps := map[string][]departureInfo{
+		"Dublin": {{Minutes: "Leaving", Platform: "2"}, {Minutes: "4", Platform: "2"}, {Minutes: "9", Platform: "2"}, {Minutes: "24", Platform: "2"}},
+	}
+
+	got := formatDepartures(deps, opts)
+	for _, want := range []string{
+		"miss:Leaving now | Platform 2",
+		"miss:   in 4 min | Platform 2",
+		"next:   in 9 min | Platform 2",
+		"ok:  in 24 min | Platform 2",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+// Closes stop after a number of writes
+type stopAfterWriter struct {
+	strings.Builder
+	writes int
+	stop   chan struct{}
+}
+
+func (w *stopAfterWriter) Write(p []byte) (int, error) {
+	if w.writes--; w.writes == 0 {
+		close(w.stop)
+	}
+	return w.Builder.Write(p)
+}
+
+func TestWatchJSON(t *testing.T) {
+	serveJSON(t, `{"root": {"station": [{"abbr": "POWL", "etd": [{
+		"destination": "Dublin", "estimate": [{"minutes": "4", "platform": "2"}]
+	}]}]}}`)
+
+	out := &stopAfterWriter{writes: 3, stop: make(chan struct{})}
+	watchJSON(out, "fake_key", []string{"POWL"}, time.Millisecond, out.stop)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected one JSON object per refresh, got %q", out.String())
+	}
+	for _, line := range lines {
+		var got departuresJSON
+		if err := json.Unmarshal([]byte(line), &got); err != nil || got.Station != "POWL" || len(got.Departures["Dublin"]) != 1 {
+			t.Errorf("expected POWL departures, got %q (err %v)", line, err)
+		}
+	}
+}
+
+func TestRefreshKeepsCursorStation(t *testing.T) {
+	stations := []station{{Name: "Embarcadero", Abbr: "EMBR"}, {Name: "Montgomery St.", Abbr: "MONT"}, {Name: "Powell St.", Abbr: "POWL"}}
+	m := model{cursor: 2, stations: stations}
+
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	updated, _ = updated.Update([]station{{Name: "Montgomery St.", Abbr: "MONT"}, {Name: "Powell St.", Abbr: "POWL"}, {Name: "Civic Center", Abbr: "CIVC"}})
+	if m2 := updated.(model); m2.cursor != 1 {
+		t.Errorf("expected the cursor back on POWL (1), got %d", m2.cursor)
+	}
+
+	//	Gone after the reload: back to the top
+	updated, _ = updated.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	updated, _ = updated.Update([]station{{Name: "Embarcadero", Abbr: "EMBR"}})
+	if m2 := updated.(model); m2.cursor != 0 || m2.keepCursor != "" {
+		t.Errorf("expected the cursor at the top, got %d", m2.cursor)
+	}
+
+	//	The station remembered from the last run doesn't take over a reload
+	m = model{cursor: 2, stations: stations, lastStation: "EMBR"}
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	updated, cmd := updated.Update(stations)
+	if m2 := updated.(model); m2.cursor != 2 || m2.loading.Abbr != "" || cmd != nil {
+		t.Errorf("expected the cursor to stay on POWL (2) without loading, got %d loading %q", m2.cursor, m2.loading.Abbr)
+	}
+}
+
+func TestPlatformLabels(t *testing.T) {
+	m := model{platformLabels: map[string]map[string]string{"MONT": {"1": "lower"}}}
+	deps := map[string][]departureInfo{
+		"Antioch":   {{Minutes: "4", Platform: "2", Direction: "North"}},
+		"Daly City": {{Minutes: "6", Platform: "1", Direction: "South"}},
+	}
+
+	got := formatDepartures(deps, m.optionsFor("mont"))
+	for _, want := range []string{"Southbound (Platform 1 (lower))", "in 6 min | Platform 1 (lower)", "Northbound (Platform 2)", "in 4 min | Platform 2"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+	if got := formatDepartures(deps, m.optionsFor("POWL")); strings.Contains(got, "lower") {
+		t.Errorf("expected labels only at MONT, got %q", got)
+	}
+}
+
+func TestAutoTheme(t *testing.T) {
+	dark := func() bool { return true }
+	light := func() bool { return false }
+
+	tests := []struct {
+		colorfgbg string
+		probe     func() bool
+		want      string
+	}{
+		{"15;0", light, "dark"},
+		{"0;15", dark, "light"},
+		{"0;default;7", dark, "light"},
+		{"", dark, "dark"},
+		{"", light, "light"},
+		{"junk", light, "light"},
+	}
+	for _, tt := range tests {
+		if got := autoTheme(tt.colorfgbg, tt.probe); got != tt.want {
+			t.Errorf("autoTheme(%q) = %q, want %q", tt.colorfgbg, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkView(b *testing.B) {
+	//	The full BART network is 50 stations
+	stations := make([]station, 50)
+	for i := range stations {
+		stations[i] = station{Name: fmt.Sprintf("Station %d", i), Abbr: fmt.Sprintf("S%03d", i), City: "Oakland"}
+	}
+	deps := map[string][]departureInfo{
+		"Dublin/Pleasanton": {{Minutes: "4", Platform: "2"}, {Minutes: "19", Platform: "2"}},
+		"Richmond":          {{Minutes: "Leaving", Platform: "1"}, {Minutes: "12", Platform: "1"}},
+	}
+	m := model{
+		stations: stations,
+		cursor:   25,
+		width:    120,
+		info:     "Station 25\n\n" + formatDepartures(deps, renderOptions{}),
+	}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		_ = m.View()
+	}
+}
+
+func TestStationListCache(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	m := initialModel("", nil)
+	m.stations = []station{{Name: "Embarcadero", Abbr: "EMBR"}, {Name: "Powell St.", Abbr: "POWL"}}
+	m.View()
+
+	//	A sentinel shows whether the next frame reused the cached list
+	cached := func(m model) bool {
+		m.listCache.rendered = "cached\n"
+		return strings.Contains(m.View(), "cached")
+	}
+
+	m.info = "Powell St.\n\nRichmond\n  in 4 min"
+	if !cached(m) {
+		t.Error("new departures should reuse the station list")
+	}
+	m.cursor = 1
+	if cached(m) {
+		t.Error("moving the cursor should render the list again")
+	}
+	m.View()
+	m.selected = map[string]bool{}
+	m.selected["POWL"] = true
+	if cached(m) {
+		t.Error("selecting a station should render the list again")
+	}
+	m.View()
+	m = m.toggleFavorite("EMBR")
+	if cached(m) {
+		t.Error("starring a station should render the list again")
+	}
+	m.View()
+	m.stations = slices.Clone(m.stations)
+	if cached(m) {
+		t.Error("a reloaded station list should render again")
+	}
+}